@@ -0,0 +1,125 @@
+/**
+ * @Time    :2023/7/20 16:30
+ * @Author  :Xiaoyu.Zhang
+ */
+
+// mt-encrypt 离线加密存量明文数据（信封加密），V1 格式的旧密文将解密后按当前格式重新加密
+//
+//	mt-encrypt -dsn "user:pass@tcp(127.0.0.1:3306)/tenant_db?charset=utf8mb4&parseTime=True" \
+//		-kms-file master_keys.json -master-key-id master-1 \
+//		-table users -columns phone,id_card -tenant-column tenant_id -tenants t1,t2
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/melf-xyzh/multi-tenancy/plugin"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"os"
+	"strings"
+)
+
+func main() {
+	var (
+		dsn          = flag.String("dsn", "", "待加密数据所在数据库的 DSN")
+		masterDSN    = flag.String("master-dsn", "", "保存数据密钥的主库 DSN，默认同 -dsn")
+		kmsFile      = flag.String("kms-file", "", "主密钥文件，内容为 JSON：{\"主密钥ID\": \"Base64 编码的主密钥\"}")
+		masterKeyId  = flag.String("master-key-id", "", "加密新数据密钥使用的主密钥ID")
+		table        = flag.String("table", "", "表名")
+		primaryKey   = flag.String("pk", "id", "主键字段名")
+		columns      = flag.String("columns", "", "需要加密的字段，逗号分隔")
+		storage      = flag.String("storage", "text", "密文存储方式：text、binary")
+		bindPK       = flag.Bool("bind-pk", false, "密文是否绑定所在行的主键，须与字段的 mt:\"encrypt;bind\" 声明一致")
+		tenantColumn = flag.String("tenant-column", "", "租户字段名，指定后按租户筛选数据")
+		tenants      = flag.String("tenants", "", "租户ID，逗号分隔，用于选择租户的数据密钥")
+		batchSize    = flag.Int("batch", 500, "每批处理的行数")
+		dryRun       = flag.Bool("dry-run", false, "仅统计，不写入数据库")
+	)
+	flag.Parse()
+	if *dsn == "" || *kmsFile == "" || *masterKeyId == "" || *table == "" || *columns == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *masterDSN == "" {
+		*masterDSN = *dsn
+	}
+	err := run(*dsn, *masterDSN, *kmsFile, *masterKeyId, plugin.EncryptExistingOptions{
+		Table:          *table,
+		PrimaryKey:     *primaryKey,
+		Columns:        splitList(*columns),
+		Storage:        *storage,
+		BindPrimaryKey: *bindPK,
+		TenantColumn:   *tenantColumn,
+		TenantIds:      splitList(*tenants),
+		BatchSize:      *batchSize,
+		DryRun:         *dryRun,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run
+/**
+ *  @Description: 连接数据库并加密存量明文数据
+ *  @param dsn
+ *  @param masterDSN
+ *  @param kmsFile
+ *  @param masterKeyId
+ *  @param opts
+ *  @return err
+ */
+func run(dsn, masterDSN, kmsFile, masterKeyId string, opts plugin.EncryptExistingOptions) (err error) {
+	config := &gorm.Config{Logger: plugin.NewRedactLogger(logger.Default.LogMode(logger.Warn))}
+	db, err := gorm.Open(mysql.Open(dsn), config)
+	if err != nil {
+		return
+	}
+	masterDB := db
+	if masterDSN != dsn {
+		masterDB, err = gorm.Open(mysql.Open(masterDSN), config)
+		if err != nil {
+			return
+		}
+	}
+	kms, err := plugin.NewFileKMS(kmsFile)
+	if err != nil {
+		return
+	}
+	envelope, err := plugin.NewEnvelope(masterDB, kms, masterKeyId, 0)
+	if err != nil {
+		return
+	}
+	mt := &plugin.MultiTenancy{}
+	mt.Register("", nil)
+	err = db.Use(mt)
+	if err != nil {
+		return
+	}
+	mt.SetEnvelopeEncryption(envelope)
+	result, err := mt.EncryptExisting(context.Background(), nil, opts)
+	if err != nil {
+		return
+	}
+	fmt.Printf("扫描：%d，加密：%d，重新加密：%d，跳过：%d\n", result.Scanned, result.Encrypted, result.Reencrypted, result.Skipped)
+	return
+}
+
+// splitList
+/**
+ *  @Description: 解析逗号分隔的参数
+ *  @param value
+ *  @return list
+ */
+func splitList(value string) (list []string) {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return
+}
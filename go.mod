@@ -5,6 +5,9 @@ go 1.17
 require (
 	github.com/bwmarrin/snowflake v0.3.0
 	github.com/melf-xyzh/gmsm v0.0.0-20230620035226-0e35c0914d48
+	github.com/tjfoc/gmsm v1.4.1
+	golang.org/x/crypto v0.0.0-20220525230936-793ad666bf5e
+	golang.org/x/sys v0.0.0-20210615035016-665e8c7367d1
 	gorm.io/driver/mysql v1.5.0
 	gorm.io/gorm v1.25.1
 )
@@ -13,5 +16,4 @@ require (
 	github.com/go-sql-driver/mysql v1.7.0 // indirect
 	github.com/jinzhu/inflection v1.0.0 // indirect
 	github.com/jinzhu/now v1.1.5 // indirect
-	github.com/tjfoc/gmsm v1.4.1 // indirect
 )
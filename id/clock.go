@@ -0,0 +1,135 @@
+/**
+ * @Time    :2023/7/28 09:40
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package id
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrClockBackward 时钟回拨超出等待策略
+var ErrClockBackward = errors.New("时钟回拨，拒绝生成ID")
+
+// Clock 时钟，可注入用于测试
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+// systemClock 系统时钟，time.Now 包含单调时钟读数，进程内不受系统时间调整影响
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// ClockPolicy 时钟回拨策略
+type ClockPolicy int
+
+const (
+	ClockWait ClockPolicy = iota // 回拨不超过最大等待时间时等待时钟追上，否则返回错误
+	ClockFail                    // 立即返回错误
+)
+
+// 默认最大等待时间
+const defaultMaxClockWait = 10 * time.Millisecond
+
+// ClockStats 时钟回拨统计
+type ClockStats struct {
+	Regressions uint64        // 检测到回拨的次数
+	Failures    uint64        // 因回拨拒绝生成ID的次数
+	MaxBackward time.Duration // 最大回拨时间
+	Waited      time.Duration // 累计等待时间
+}
+
+// SetClock
+/**
+ *  @Description: 设置时钟，用于测试时注入可控时钟
+ *  @receiver generator
+ *  @param clock
+ */
+func (generator *DistributedIdGenerator) SetClock(clock Clock) {
+	generator.mu.Lock()
+	defer generator.mu.Unlock()
+	if clock == nil {
+		clock = systemClock{}
+	}
+	generator.clock = clock
+}
+
+// SetClockPolicy
+/**
+ *  @Description: 设置时钟回拨策略
+ *  @receiver generator
+ *  @param policy
+ *  @param maxWait ClockWait 的最大等待时间，小于等于0时使用默认值（10毫秒）
+ */
+func (generator *DistributedIdGenerator) SetClockPolicy(policy ClockPolicy, maxWait time.Duration) {
+	generator.mu.Lock()
+	defer generator.mu.Unlock()
+	if maxWait <= 0 {
+		maxWait = defaultMaxClockWait
+	}
+	generator.policy = policy
+	generator.maxWait = maxWait
+}
+
+// ClockStats
+/**
+ *  @Description: 获取时钟回拨统计
+ *  @receiver generator
+ *  @return ClockStats
+ */
+func (generator *DistributedIdGenerator) ClockStats() ClockStats {
+	generator.mu.Lock()
+	defer generator.mu.Unlock()
+	return generator.stats
+}
+
+// millis
+/**
+ *  @Description: 自起始时间起的毫秒数
+ *  @receiver generator
+ *  @return int64
+ */
+func (generator *DistributedIdGenerator) millis() int64 {
+	return generator.clock.Now().Sub(generator.epoch).Milliseconds()
+}
+
+// waitClock
+/**
+ *  @Description: 检测时钟回拨，按策略等待时钟追上上次生成ID的时间
+ *  @receiver generator
+ *  @param now
+ *  @return int64 追上后的时间
+ *  @return error
+ */
+func (generator *DistributedIdGenerator) waitClock(now int64) (int64, error) {
+	backward := time.Duration(generator.time-now) * time.Millisecond
+	generator.stats.Regressions++
+	if backward > generator.stats.MaxBackward {
+		generator.stats.MaxBackward = backward
+	}
+	if generator.policy == ClockWait {
+		var waited time.Duration
+		for now < generator.time && waited+backward <= generator.maxWait {
+			generator.clock.Sleep(backward)
+			waited += backward
+			now = generator.millis()
+			backward = time.Duration(generator.time-now) * time.Millisecond
+		}
+		generator.stats.Waited += waited
+		if now >= generator.time {
+			return now, nil
+		}
+	}
+	generator.stats.Failures++
+	return now, ErrClockBackward
+}
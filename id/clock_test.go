@@ -0,0 +1,23 @@
+/**
+ * @Time    :2023/7/31 16:00
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package id
+
+import "time"
+
+// manualClock 手动推进的时钟，Sleep 时推进相应时间
+type manualClock struct {
+	now time.Time
+}
+
+func (c *manualClock) Now() time.Time {
+	return c.now
+}
+
+func (c *manualClock) Sleep(d time.Duration) {
+	if d > 0 {
+		c.now = c.now.Add(d)
+	}
+}
@@ -0,0 +1,152 @@
+/**
+ * @Time    :2023/7/28 14:15
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package id
+
+import (
+	"math"
+	"time"
+)
+
+// timeShift
+/**
+ *  @Description: 时间戳的偏移位数
+ *  @receiver layout
+ *  @return uint8
+ */
+func (layout Layout) timeShift() uint8 {
+	return layout.TenantBits + layout.NodeBits + layout.StepBits
+}
+
+// Time
+/**
+ *  @Description: 按ID结构获取ID的生成时间（毫秒精度）
+ *  @receiver layout
+ *  @param id
+ *  @return time.Time
+ */
+func (layout Layout) Time(id DistributedId) time.Time {
+	return time.UnixMilli(int64(id)>>layout.timeShift() + layout.Epoch)
+}
+
+// TenantNo
+/**
+ *  @Description: 按ID结构获取ID中的租户编号
+ *  @receiver layout
+ *  @param id
+ *  @return int64
+ */
+func (layout Layout) TenantNo(id DistributedId) int64 {
+	return int64(id) >> (layout.NodeBits + layout.StepBits) & layout.max(layout.TenantBits)
+}
+
+// Node
+/**
+ *  @Description: 按ID结构获取ID中的节点
+ *  @receiver layout
+ *  @param id
+ *  @return int64
+ */
+func (layout Layout) Node(id DistributedId) int64 {
+	return int64(id) >> layout.StepBits & layout.max(layout.NodeBits)
+}
+
+// Step
+/**
+ *  @Description: 按ID结构获取ID中的序列号
+ *  @receiver layout
+ *  @param id
+ *  @return int64
+ */
+func (layout Layout) Step(id DistributedId) int64 {
+	return int64(id) & layout.max(layout.StepBits)
+}
+
+// MinIdAt
+/**
+ *  @Description: 按ID结构获取指定时间（毫秒）生成的最小ID，早于起始时间时为0
+ *  @receiver layout
+ *  @param t
+ *  @return DistributedId
+ */
+func (layout Layout) MinIdAt(t time.Time) DistributedId {
+	ms := t.UnixMilli() - layout.Epoch
+	if ms < 0 {
+		return 0
+	}
+	if ms > math.MaxInt64>>layout.timeShift() {
+		return math.MaxInt64
+	}
+	return DistributedId(ms << layout.timeShift())
+}
+
+// MaxIdAt
+/**
+ *  @Description: 按ID结构获取指定时间（毫秒）生成的最大ID，早于起始时间时为-1
+ *  @receiver layout
+ *  @param t
+ *  @return DistributedId
+ */
+func (layout Layout) MaxIdAt(t time.Time) DistributedId {
+	ms := t.UnixMilli() - layout.Epoch
+	if ms < 0 {
+		return -1
+	}
+	if ms >= math.MaxInt64>>layout.timeShift() {
+		return math.MaxInt64
+	}
+	return DistributedId((ms+1)<<layout.timeShift() - 1)
+}
+
+// MinIdAt
+/**
+ *  @Description: 获取指定时间（毫秒）生成的最小ID，可用于按主键查询时间范围：id >= MinIdAt(start) AND id < MinIdAt(end)。
+ *  DefaultLayout 与 TenantLayout 的起始时间及时间戳位置相同，两者生成的ID均适用
+ *  @param t
+ *  @return DistributedId
+ */
+func MinIdAt(t time.Time) DistributedId {
+	return DefaultLayout.MinIdAt(t)
+}
+
+// MaxIdAt
+/**
+ *  @Description: 获取指定时间（毫秒）生成的最大ID，可用于按主键查询时间范围：id BETWEEN MinIdAt(start) AND MaxIdAt(end)
+ *  @param t
+ *  @return DistributedId
+ */
+func MaxIdAt(t time.Time) DistributedId {
+	return DefaultLayout.MaxIdAt(t)
+}
+
+// Time
+/**
+ *  @Description: 获取ID的生成时间（毫秒精度），DefaultLayout 与 TenantLayout 生成的ID均适用，其他结构使用 Layout.Time
+ *  @receiver t
+ *  @return time.Time
+ */
+func (t DistributedId) Time() time.Time {
+	return DefaultLayout.Time(t)
+}
+
+// Node
+/**
+ *  @Description: 按 DefaultLayout 获取ID中的节点，其他结构使用 Layout.Node
+ *  @receiver t
+ *  @return int64
+ */
+func (t DistributedId) Node() int64 {
+	return DefaultLayout.Node(t)
+}
+
+// Step
+/**
+ *  @Description: 按 DefaultLayout 获取ID中的序列号，其他结构使用 Layout.Step
+ *  @receiver t
+ *  @return int64
+ */
+func (t DistributedId) Step() int64 {
+	return DefaultLayout.Step(t)
+}
@@ -0,0 +1,172 @@
+/**
+ * @Time    :2023/7/27 14:10
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package id
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// FileLockAllocator 基于本地锁文件的节点分配，适用于单机多进程，通过文件锁（flock、LockFileEx）占用节点，进程退出时由操作系统释放
+type FileLockAllocator struct {
+	dir  string
+	opts LeaseOptions
+	node int64
+	file *os.File // 已锁定的锁文件，释放前保持打开
+	leaseKeeper
+}
+
+// NewFileLockAllocator
+/**
+ *  @Description: 创建基于锁文件的节点分配
+ *  @param dir 锁文件目录，不存在时创建
+ *  @param opts 仅使用 Namespace、MaxNode，TTL、Heartbeat 用于检查锁文件是否被删除或替换
+ *  @return allocator
+ *  @return err
+ */
+func NewFileLockAllocator(dir string, opts LeaseOptions) (allocator *FileLockAllocator, err error) {
+	err = os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return
+	}
+	allocator = &FileLockAllocator{
+		dir:  dir,
+		opts: opts.withDefault(),
+		node: -1,
+	}
+	return
+}
+
+// lockFile
+/**
+ *  @Description: 节点的锁文件路径
+ *  @receiver a
+ *  @param node
+ *  @return string
+ */
+func (a *FileLockAllocator) lockFile(node int64) string {
+	name := "node-" + strconv.FormatInt(node, 10) + ".lock"
+	if a.opts.Namespace != "" {
+		name = a.opts.Namespace + "-" + name
+	}
+	return filepath.Join(a.dir, name)
+}
+
+// Acquire
+/**
+ *  @Description: 锁定未被其他进程锁定的节点锁文件
+ *  @receiver a
+ *  @param ctx
+ *  @return node
+ *  @return err
+ */
+func (a *FileLockAllocator) Acquire(ctx context.Context) (node int64, err error) {
+	if a.node >= 0 {
+		err = errors.New("已申请节点 " + strconv.FormatInt(a.node, 10))
+		return
+	}
+	for node = 0; node <= a.opts.maxNode(); node++ {
+		var file *os.File
+		file, err = os.OpenFile(a.lockFile(node), os.O_CREATE|os.O_RDWR, 0o644)
+		if err != nil {
+			return
+		}
+		var locked bool
+		locked, err = tryLockFile(file)
+		if err != nil || !locked {
+			_ = file.Close()
+			if err != nil {
+				return
+			}
+			continue
+		}
+		// 记录实例标识，便于排查
+		err = file.Truncate(0)
+		if err == nil {
+			_, err = file.WriteAt([]byte(a.opts.Owner), 0)
+		}
+		if err != nil {
+			_ = unlockFile(file)
+			_ = file.Close()
+			return
+		}
+		a.node = node
+		a.file = file
+		a.start(a.opts.Heartbeat, a.opts.TTL, a.renew)
+		return
+	}
+	err = errors.New("目录 " + a.dir + " 没有空闲节点")
+	return
+}
+
+// renew
+/**
+ *  @Description: 确认锁文件未被删除或替换，否则其他实例可能已锁定同名的新文件
+ *  @receiver a
+ *  @param ctx
+ *  @return ok
+ *  @return err
+ */
+func (a *FileLockAllocator) renew(_ context.Context) (ok bool, err error) {
+	info, err := os.Stat(a.lockFile(a.node))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return
+	}
+	locked, err := a.file.Stat()
+	if err != nil {
+		return
+	}
+	ok = os.SameFile(info, locked)
+	return
+}
+
+// LimitNode
+/**
+ *  @Description: 申请的节点不超过 maxNode
+ *  @receiver a
+ *  @param maxNode
+ */
+func (a *FileLockAllocator) LimitNode(maxNode int64) {
+	a.opts.limitNode(maxNode)
+	return
+}
+
+// Release
+/**
+ *  @Description: 停止检查并解锁锁文件，Lost 随之关闭；不删除锁文件，避免删除其他实例已锁定的文件
+ *  @receiver a
+ *  @param ctx
+ *  @return err
+ */
+func (a *FileLockAllocator) Release(ctx context.Context) (err error) {
+	if a.node < 0 {
+		return
+	}
+	a.close()
+	err = unlockFile(a.file)
+	if closeErr := a.file.Close(); err == nil {
+		err = closeErr
+	}
+	a.file = nil
+	a.node = -1
+	return
+}
+
+// Lost
+/**
+ *  @Description: 租约丢失通知
+ *  @receiver a
+ *  @return <-chan struct{}
+ */
+func (a *FileLockAllocator) Lost() <-chan struct{} {
+	return a.lostChan()
+}
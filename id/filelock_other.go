@@ -0,0 +1,36 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!windows
+
+/**
+ * @Time    :2023/7/27 15:20
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package id
+
+import (
+	"errors"
+	"os"
+)
+
+// tryLockFile
+/**
+ *  @Description: 当前系统不支持文件锁
+ *  @param file
+ *  @return locked
+ *  @return err
+ */
+func tryLockFile(file *os.File) (locked bool, err error) {
+	err = errors.New("当前系统不支持锁文件分配节点")
+	return
+}
+
+// unlockFile
+/**
+ *  @Description: 当前系统不支持文件锁
+ *  @param file
+ *  @return error
+ */
+func unlockFile(file *os.File) error {
+	return nil
+}
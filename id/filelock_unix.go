@@ -0,0 +1,40 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+/**
+ * @Time    :2023/7/27 15:20
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package id
+
+import (
+	"os"
+	"syscall"
+)
+
+// tryLockFile
+/**
+ *  @Description: 以非阻塞方式对锁文件加排他锁（flock）
+ *  @param file
+ *  @return locked 已被其他进程锁定时为 false
+ *  @return err
+ */
+func tryLockFile(file *os.File) (locked bool, err error) {
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+	locked = err == nil
+	return
+}
+
+// unlockFile
+/**
+ *  @Description: 解锁锁文件
+ *  @param file
+ *  @return error
+ */
+func unlockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,40 @@
+//go:build windows
+// +build windows
+
+/**
+ * @Time    :2023/7/27 15:20
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package id
+
+import (
+	"golang.org/x/sys/windows"
+	"os"
+)
+
+// tryLockFile
+/**
+ *  @Description: 以非阻塞方式对锁文件的首字节加排他锁（LockFileEx）
+ *  @param file
+ *  @return locked 已被其他进程锁定时为 false
+ *  @return err
+ */
+func tryLockFile(file *os.File) (locked bool, err error) {
+	err = windows.LockFileEx(windows.Handle(file.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, new(windows.Overlapped))
+	if err == windows.ERROR_LOCK_VIOLATION {
+		return false, nil
+	}
+	locked = err == nil
+	return
+}
+
+// unlockFile
+/**
+ *  @Description: 解锁锁文件
+ *  @param file
+ *  @return error
+ */
+func unlockFile(file *os.File) error {
+	return windows.UnlockFileEx(windows.Handle(file.Fd()), 0, 1, 0, new(windows.Overlapped))
+}
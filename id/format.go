@@ -0,0 +1,83 @@
+/**
+ * @Time    :2023/7/31 10:20
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package id
+
+import (
+	"crypto/rand"
+	"database/sql/driver"
+	"fmt"
+)
+
+// ID 分布式ID：DistributedId、ULID、UUID
+type ID interface {
+	fmt.Stringer
+	driver.Valuer
+}
+
+// Generator 分布式ID生成器：DistributedIdGenerator、SonyflakeGenerator、ULIDGenerator、UUIDv7Generator
+type Generator interface {
+	NewID() (ID, error)
+}
+
+var (
+	_ Generator = (*DistributedIdGenerator)(nil)
+	_ Generator = (*SonyflakeGenerator)(nil)
+	_ Generator = (*ULIDGenerator)(nil)
+	_ Generator = (*UUIDv7Generator)(nil)
+)
+
+// NewID
+/**
+ *  @Description: 实现 Generator，同 CreateIdE
+ *  @receiver generator
+ *  @return ID
+ *  @return error
+ */
+func (generator *DistributedIdGenerator) NewID() (ID, error) {
+	id, err := generator.CreateIdE()
+	if err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// unixMilli
+/**
+ *  @Description: 时钟的 Unix 毫秒数
+ *  @param clock
+ *  @return int64
+ */
+func unixMilli(clock Clock) int64 {
+	return clock.Now().UnixMilli()
+}
+
+// randomBytes
+/**
+ *  @Description: 使用 crypto/rand 填充随机数
+ *  @param b
+ *  @return err
+ */
+func randomBytes(b []byte) (err error) {
+	_, err = rand.Read(b)
+	return
+}
+
+// unquoteJSON
+/**
+ *  @Description: 解析 JSON 字符串，null 时返回 nil
+ *  @param b
+ *  @return []byte
+ *  @return bool 是否为字符串或 null
+ */
+func unquoteJSON(b []byte) ([]byte, bool) {
+	if string(b) == "null" {
+		return nil, true
+	}
+	if len(b) < 2 || b[0] != '"' || b[len(b)-1] != '"' {
+		return nil, false
+	}
+	return b[1 : len(b)-1], true
+}
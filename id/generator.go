@@ -5,40 +5,244 @@
 
 package id
 
-import "github.com/bwmarrin/snowflake"
+import (
+	"errors"
+	"github.com/bwmarrin/snowflake"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 时间戳的最小位数（约17年）
+const minTimeBits = 39
+
+// ErrTimeOverflow 当前时间超出ID结构时间戳位数可表示的范围
+var ErrTimeOverflow = errors.New("当前时间超出ID结构时间戳位数可表示的范围，拒绝生成ID")
+
+// Layout ID结构：时间戳（毫秒，自 Epoch 起）| 租户编号 | 节点 | 序列号，时间戳占用其余位数
+type Layout struct {
+	Epoch      int64 // 起始时间（Unix 毫秒）
+	TenantBits uint8 // 租户编号位数，为0时不包含租户编号
+	NodeBits   uint8 // 节点位数
+	StepBits   uint8 // 序列号位数
+}
+
+// DefaultLayout 默认ID结构，与雪花ID一致：41位时间戳 | 10位节点 | 12位序列号
+var DefaultLayout = Layout{Epoch: 1288834974657, NodeBits: 10, StepBits: 12}
+
+// TenantLayout 包含租户编号的ID结构，默认支持 256 个租户、64 个节点，每个节点每毫秒生成 256 个ID
+var TenantLayout = Layout{Epoch: 1288834974657, TenantBits: 8, NodeBits: 6, StepBits: 8}
+
+// Validate
+/**
+ *  @Description: 校验ID结构
+ *  @receiver layout
+ *  @return err
+ */
+func (layout Layout) Validate() (err error) {
+	if layout.StepBits == 0 {
+		return errors.New("序列号位数须大于0")
+	}
+	if bits := int(layout.TenantBits) + int(layout.NodeBits) + int(layout.StepBits); bits > 63-minTimeBits {
+		return errors.New("租户编号、节点、序列号位数之和须小于等于 " + strconv.Itoa(63-minTimeBits) + "，实际为 " + strconv.Itoa(bits))
+	}
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	if layout.Epoch < 0 || layout.Epoch > now {
+		return errors.New("起始时间须在 1970-01-01 至当前时间之间")
+	}
+	if now-layout.Epoch > layout.maxTime() {
+		return errors.New("时间戳位数不足，仅可表示至 " + time.UnixMilli(layout.Epoch+layout.maxTime()).Format(time.RFC3339) + "，请调整起始时间或减少租户编号、节点、序列号位数")
+	}
+	return
+}
+
+// maxTime
+/**
+ *  @Description: 时间戳（自 Epoch 起的毫秒数）的最大值
+ *  @receiver layout
+ *  @return int64
+ */
+func (layout Layout) maxTime() int64 {
+	return layout.max(63 - layout.timeShift())
+}
+
+// max
+/**
+ *  @Description: 指定位数的最大值
+ *  @receiver layout
+ *  @param bits
+ *  @return int64
+ */
+func (layout Layout) max(bits uint8) int64 {
+	return -1 ^ (-1 << bits)
+}
+
+// MaxNode
+/**
+ *  @Description: 最大节点
+ *  @receiver layout
+ *  @return int64
+ */
+func (layout Layout) MaxNode() int64 {
+	return layout.max(layout.NodeBits)
+}
 
 type DistributedIdGenerator struct {
 	TenantId string
-	Node     *snowflake.Node
+	TenantNo int64 // 租户编号，ID结构包含租户编号时写入ID
+	// Deprecated: 使用全局配置的雪花ID节点，仅为兼容保留，ID 由 CreateId 按生成器的 Layout 生成，勿与 CreateId 混用
+	Node   *snowflake.Node
+	layout Layout
+	node   int64
+	epoch  time.Time
+	mu     sync.Mutex
+	time   int64
+	step   int64
+	// 时钟回拨
+	clock   Clock
+	policy  ClockPolicy
+	maxWait time.Duration
+	stats   ClockStats
+	// 节点租约丢失通知，关闭后拒绝生成ID
+	lost <-chan struct{}
 }
 
 // InitDistributedIdGenerator
 /**
- *  @Description: 初始化分布式ID生成器
+ *  @Description: 初始化分布式ID生成器，ID结构为 DefaultLayout
  *  @param tenantId
  *  @param node
  *  @return generator
  *  @return err
  */
 func InitDistributedIdGenerator(tenantId string, node int64) (generator *DistributedIdGenerator, err error) {
-	newNode, nodeErr := snowflake.NewNode(node)
-	if nodeErr != nil {
-		err = nodeErr
+	generator, err = NewDistributedIdGenerator(tenantId, 0, node, DefaultLayout)
+	if err != nil {
+		return
+	}
+	generator.Node, _ = snowflake.NewNode(node)
+	return
+}
+
+// InitTenantIdGenerator
+/**
+ *  @Description: 初始化包含租户编号的分布式ID生成器，ID 结构为 TenantLayout，可通过 TenantOf 由ID获取租户
+ *  @param tenantId
+ *  @param tenantNo 租户编号，各租户唯一
+ *  @param node 节点，同一租户的各实例唯一
+ *  @return generator
+ *  @return err
+ */
+func InitTenantIdGenerator(tenantId string, tenantNo int64, node int64) (generator *DistributedIdGenerator, err error) {
+	return NewDistributedIdGenerator(tenantId, tenantNo, node, TenantLayout)
+}
+
+// NewDistributedIdGenerator
+/**
+ *  @Description: 按指定的ID结构初始化分布式ID生成器，各生成器的结构相互独立
+ *  @param tenantId
+ *  @param tenantNo 租户编号，ID结构不包含租户编号时须为0
+ *  @param node 节点
+ *  @param layout ID结构
+ *  @return generator
+ *  @return err
+ */
+func NewDistributedIdGenerator(tenantId string, tenantNo int64, node int64, layout Layout) (generator *DistributedIdGenerator, err error) {
+	err = layout.Validate()
+	if err != nil {
+		return
+	}
+	if tenantNo < 0 || tenantNo > layout.max(layout.TenantBits) {
+		err = errors.New("租户编号须在 0 ~ " + strconv.FormatInt(layout.max(layout.TenantBits), 10) + " 之间")
+		return
+	}
+	if node < 0 || node > layout.MaxNode() {
+		err = errors.New("节点须在 0 ~ " + strconv.FormatInt(layout.MaxNode(), 10) + " 之间")
 		return
 	}
+	curTime := time.Now()
 	generator = &DistributedIdGenerator{
 		TenantId: tenantId,
-		Node:     newNode,
+		TenantNo: tenantNo,
+		layout:   layout,
+		node:     node,
+		// 使用单调时钟
+		epoch:   curTime.Add(time.Unix(layout.Epoch/1000, (layout.Epoch%1000)*1000000).Sub(curTime)),
+		clock:   systemClock{},
+		maxWait: defaultMaxClockWait,
 	}
 	return
 }
 
+// Layout
+/**
+ *  @Description: 获取生成器的ID结构
+ *  @receiver generator
+ *  @return Layout
+ */
+func (generator *DistributedIdGenerator) Layout() Layout {
+	return generator.layout
+}
+
 // CreateId
 /**
- *  @Description: 创建一个分布式ID（雪花ID）
+ *  @Description: 创建一个分布式ID（雪花ID），时钟回拨超出策略时 panic，需处理错误时使用 CreateIdE（系统时钟为单调时钟，进程内不会回拨）
  *  @return DistributedId
  */
 func (generator *DistributedIdGenerator) CreateId() DistributedId {
-	id := generator.Node.Generate()
-	return DistributedId(id.Int64())
+	id, err := generator.CreateIdE()
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+// CreateIdE
+/**
+ *  @Description: 创建一个分布式ID（雪花ID），检测到时钟回拨时按策略等待或返回 ErrClockBackward，时间超出时间戳位数时返回 ErrTimeOverflow，
+ *  节点租约丢失后返回 ErrLeaseLost
+ *  @receiver generator
+ *  @return id
+ *  @return err
+ */
+func (generator *DistributedIdGenerator) CreateIdE() (id DistributedId, err error) {
+	layout := generator.layout
+	select {
+	case <-generator.lost:
+		err = ErrLeaseLost
+		return
+	default:
+	}
+	generator.mu.Lock()
+	defer generator.mu.Unlock()
+	now := generator.millis()
+	if now < generator.time {
+		now, err = generator.waitClock(now)
+		if err != nil {
+			return
+		}
+	}
+	if now == generator.time {
+		generator.step = (generator.step + 1) & layout.max(layout.StepBits)
+		if generator.step == 0 {
+			// 当前毫秒的序列号已用完，等待下一毫秒
+			for now <= generator.time {
+				generator.clock.Sleep(time.Millisecond - generator.clock.Now().Sub(generator.epoch)%time.Millisecond)
+				now = generator.millis()
+			}
+		}
+	} else {
+		generator.step = 0
+	}
+	if now > layout.maxTime() {
+		// 超出时间戳位数时高位将溢出，生成重复或为负数的ID
+		err = ErrTimeOverflow
+		return
+	}
+	generator.time = now
+	id = DistributedId(now<<(layout.TenantBits+layout.NodeBits+layout.StepBits) |
+		generator.TenantNo<<(layout.NodeBits+layout.StepBits) |
+		generator.node<<layout.StepBits |
+		generator.step)
+	return
 }
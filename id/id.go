@@ -6,43 +6,102 @@
 package id
 
 import (
+	"bytes"
 	"database/sql/driver"
 	"errors"
 	"fmt"
-	"reflect"
+	"math"
 	"strconv"
-	"strings"
-	"unsafe"
 )
 
 type DistributedId int64
 
+var jsonNull = []byte("null")
+
+// String
+/**
+ *  @Description: 转换为十进制字符串
+ *  @receiver t
+ *  @return string
+ */
+func (t DistributedId) String() string {
+	return strconv.FormatInt(int64(t), 10)
+}
+
+// MarshalText
+/**
+ *  @Description: 实现 encoding.TextMarshaler，用于表单、URL参数等文本格式
+ *  @receiver t
+ *  @return []byte
+ *  @return error
+ */
+func (t DistributedId) MarshalText() ([]byte, error) {
+	return strconv.AppendInt(nil, int64(t), 10), nil
+}
+
+// UnmarshalText
+/**
+ *  @Description: 实现 encoding.TextUnmarshaler，空字符串解析为0
+ *  @receiver t
+ *  @param b
+ *  @return error
+ */
+func (t *DistributedId) UnmarshalText(b []byte) error {
+	if len(b) == 0 {
+		*t = 0
+		return nil
+	}
+	num, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return errors.New("无效的分布式ID：" + strconv.Quote(string(b)))
+	}
+	*t = DistributedId(num)
+	return nil
+}
+
+// UnmarshalParam
+/**
+ *  @Description: 用于 gin 等框架的表单、URL参数绑定
+ *  @receiver t
+ *  @param param
+ *  @return error
+ */
+func (t *DistributedId) UnmarshalParam(param string) error {
+	return t.UnmarshalText([]byte(param))
+}
+
 // MarshalJSON
 /**
- *  @Description: 重写MarshalJSON方法
+ *  @Description: 重写MarshalJSON方法，输出为字符串，避免前端精度丢失
  *  @receiver t
  *  @return []byte
  *  @return error
  */
 func (t DistributedId) MarshalJSON() ([]byte, error) {
-	str := strconv.FormatInt(int64(t), 10)
 	// 注意 json 字符串风格要求
-	return []byte(fmt.Sprintf("\"%v\"", str)), nil
+	return strconv.AppendQuote(nil, t.String()), nil
 }
 
 // UnmarshalJSON
 /**
- *  @Description: 重写UnmarshalJSON方法
+ *  @Description: 重写UnmarshalJSON方法，支持字符串及数字，null 时保持不变
  *  @receiver t
  *  @param b
  *  @return error
  */
 func (t *DistributedId) UnmarshalJSON(b []byte) error {
-	numStr := *(*string)(unsafe.Pointer(&b))
-	numStr = strings.ReplaceAll(numStr, "\"", "")
-	num, _ := strconv.ParseInt(numStr, 10, 64)
-	*t = DistributedId(num)
-	return nil
+	b = bytes.TrimSpace(b)
+	if bytes.Equal(b, jsonNull) {
+		return nil
+	}
+	if len(b) > 0 && b[0] == '"' {
+		str, err := strconv.Unquote(string(b))
+		if err != nil {
+			return errors.New("无效的分布式ID：" + string(b))
+		}
+		b = []byte(str)
+	}
+	return t.UnmarshalText(b)
 }
 
 // Value 写入数据库之前，对数据做类型转换
@@ -52,21 +111,133 @@ func (t DistributedId) Value() (driver.Value, error) {
 	return num, nil
 }
 
-// Scan 将数据库中取出的数据，赋值给目标类型
+// Scan 将数据库中取出的数据，赋值给目标类型，NULL 为0，可为 NULL 的字段使用 NullDistributedId
 func (t *DistributedId) Scan(v interface{}) error {
-	switch v.(type) {
-	case []uint8:
-		b := v.([]uint8)
-		numStr := *(*string)(unsafe.Pointer(&b))
-		//numStr := utils.ToString(v.([]uint8))
-		num, _ := strconv.ParseInt(numStr, 10, 64)
-		*t = DistributedId(num)
+	switch val := v.(type) {
+	case nil:
+		*t = 0
 	case int64:
-		*t = DistributedId(v.(int64))
+		*t = DistributedId(val)
+	case int32:
+		*t = DistributedId(val)
+	case int:
+		*t = DistributedId(val)
+	case uint32:
+		*t = DistributedId(val)
+	case uint64:
+		if val > math.MaxInt64 {
+			return errors.New("分布式ID超出范围：" + strconv.FormatUint(val, 10))
+		}
+		*t = DistributedId(val)
+	case float64:
+		if val != math.Trunc(val) || val < math.MinInt64 || val >= math.MaxInt64 {
+			return errors.New("无效的分布式ID：" + strconv.FormatFloat(val, 'f', -1, 64))
+		}
+		*t = DistributedId(val)
+	case []byte:
+		return t.UnmarshalText(val)
+	case string:
+		return t.UnmarshalText([]byte(val))
 	default:
-		val := reflect.ValueOf(v)
-		typ := reflect.Indirect(val).Type()
-		return errors.New(typ.Name() + "类型处理错误")
+		return errors.New(fmt.Sprintf("%T", v) + "类型处理错误")
 	}
 	return nil
 }
+
+// NullDistributedId 可为 NULL 的分布式ID
+type NullDistributedId struct {
+	DistributedId DistributedId
+	Valid         bool // DistributedId 不为 NULL 时为 true
+}
+
+// Value 写入数据库之前，对数据做类型转换
+func (n NullDistributedId) Value() (driver.Value, error) {
+	if !n.Valid {
+		return nil, nil
+	}
+	return n.DistributedId.Value()
+}
+
+// Scan 将数据库中取出的数据，赋值给目标类型
+func (n *NullDistributedId) Scan(v interface{}) error {
+	if v == nil {
+		n.DistributedId, n.Valid = 0, false
+		return nil
+	}
+	err := n.DistributedId.Scan(v)
+	n.Valid = err == nil
+	return err
+}
+
+// MarshalJSON
+/**
+ *  @Description: 为 NULL 时输出 null
+ *  @receiver n
+ *  @return []byte
+ *  @return error
+ */
+func (n NullDistributedId) MarshalJSON() ([]byte, error) {
+	if !n.Valid {
+		return jsonNull, nil
+	}
+	return n.DistributedId.MarshalJSON()
+}
+
+// UnmarshalJSON
+/**
+ *  @Description: null 解析为 NULL
+ *  @receiver n
+ *  @param b
+ *  @return error
+ */
+func (n *NullDistributedId) UnmarshalJSON(b []byte) error {
+	if bytes.Equal(bytes.TrimSpace(b), jsonNull) {
+		n.DistributedId, n.Valid = 0, false
+		return nil
+	}
+	err := n.DistributedId.UnmarshalJSON(b)
+	n.Valid = err == nil
+	return err
+}
+
+// MarshalText
+/**
+ *  @Description: 为 NULL 时输出空字符串
+ *  @receiver n
+ *  @return []byte
+ *  @return error
+ */
+func (n NullDistributedId) MarshalText() ([]byte, error) {
+	if !n.Valid {
+		return []byte{}, nil
+	}
+	return n.DistributedId.MarshalText()
+}
+
+// UnmarshalText
+/**
+ *  @Description: 空字符串解析为 NULL
+ *  @receiver n
+ *  @param b
+ *  @return error
+ */
+func (n *NullDistributedId) UnmarshalText(b []byte) error {
+	if len(b) == 0 {
+		n.DistributedId, n.Valid = 0, false
+		return nil
+	}
+	err := n.DistributedId.UnmarshalText(b)
+	n.Valid = err == nil
+	return err
+}
+
+// UnmarshalParam
+/**
+ *  @Description: 用于 gin 等框架的表单、URL参数绑定
+ *  @receiver n
+ *  @param param
+ *  @return error
+ */
+func (n *NullDistributedId) UnmarshalParam(param string) error {
+	return n.UnmarshalText([]byte(param))
+}
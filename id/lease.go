@@ -0,0 +1,443 @@
+/**
+ * @Time    :2023/7/27 10:30
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package id
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// 节点租约默认有效期
+	defaultLeaseTTL = 30 * time.Second
+)
+
+// ErrLeaseLost 节点租约已丢失，该节点可能已被其他实例使用
+var ErrLeaseLost = errors.New("节点租约已丢失，拒绝生成ID")
+
+// NodeAllocator 节点分配接口，实例启动时申请空闲节点，关闭时释放
+type NodeAllocator interface {
+	// Acquire 申请空闲节点，并定期续约
+	Acquire(ctx context.Context) (node int64, err error)
+	// Release 停止续约并释放节点
+	Release(ctx context.Context) error
+	// Lost 租约丢失（续约失败且即将过期、被其他实例接管）或释放节点时关闭，此后不应继续使用该节点生成ID
+	Lost() <-chan struct{}
+}
+
+// NodeLimiter 可限制申请范围的节点分配，InitLeasedIdGenerator 按ID结构的最大节点限制申请范围
+type NodeLimiter interface {
+	// LimitNode 申请的节点不超过 maxNode
+	LimitNode(maxNode int64)
+}
+
+// LeaseOptions 节点租约配置
+type LeaseOptions struct {
+	Namespace string        // 命名空间，如服务名或租户ID，各命名空间独立分配节点
+	MaxNode   int64         // 最大节点，默认为 ID 结构的最大节点（InitLeasedIdGenerator 指定的结构，未指定时为 DefaultLayout 的 1023）
+	TTL       time.Duration // 租约有效期，默认为30秒，超过有效期未续约的节点可被其他实例接管
+	Heartbeat time.Duration // 续约间隔，默认为有效期的 1/3
+	Owner     string        // 实例标识，默认为 主机名-进程ID-随机数
+}
+
+// withDefault
+/**
+ *  @Description: 填充默认配置
+ *  @receiver opts
+ *  @return LeaseOptions
+ */
+func (opts LeaseOptions) withDefault() LeaseOptions {
+	if opts.TTL <= 0 {
+		opts.TTL = defaultLeaseTTL
+	}
+	if opts.Heartbeat <= 0 || opts.Heartbeat >= opts.TTL {
+		opts.Heartbeat = opts.TTL / 3
+	}
+	if opts.Owner == "" {
+		opts.Owner = defaultOwner()
+	}
+	return opts
+}
+
+// maxNode
+/**
+ *  @Description: 申请的最大节点，未指定时为 DefaultLayout 的最大节点
+ *  @receiver opts
+ *  @return int64
+ */
+func (opts LeaseOptions) maxNode() int64 {
+	if opts.MaxNode <= 0 {
+		return DefaultLayout.MaxNode()
+	}
+	return opts.MaxNode
+}
+
+// limitNode
+/**
+ *  @Description: 未指定最大节点或超过 maxNode 时，最大节点设置为 maxNode
+ *  @receiver opts
+ *  @param maxNode
+ */
+func (opts *LeaseOptions) limitNode(maxNode int64) {
+	if opts.MaxNode <= 0 || opts.MaxNode > maxNode {
+		opts.MaxNode = maxNode
+	}
+	return
+}
+
+// defaultOwner
+/**
+ *  @Description: 生成实例标识：主机名-进程ID-随机数
+ *  @return string
+ */
+func defaultOwner() string {
+	hostname, _ := os.Hostname()
+	random := make([]byte, 4)
+	_, _ = rand.Read(random)
+	return hostname + "-" + strconv.Itoa(os.Getpid()) + "-" + hex.EncodeToString(random)
+}
+
+// NodeLease 节点租约，保存在主库中
+type NodeLease struct {
+	Namespace  string    `json:"namespace"  gorm:"column:namespace;primaryKey;type:varchar(64)"`
+	Node       int64     `json:"node"       gorm:"column:node;primaryKey;autoIncrement:false"`
+	Owner      string    `json:"owner"      gorm:"column:owner;type:varchar(128)"`
+	ExpireTime time.Time `json:"expireTime" gorm:"column:expire_time;index"` // 过期时间（UTC），由主库时钟计算
+}
+
+func (NodeLease) TableName() string {
+	return "mt_node_lease"
+}
+
+// leaseClock 主库的当前时间（UTC），租约的过期时间均由主库时钟计算，避免各实例时钟偏差导致提前接管
+type leaseClock struct {
+	now    string                              // 当前时间
+	expire string                              // 当前时间 + 有效期，有效期为参数
+	ttl    func(ttl time.Duration) interface{} // 有效期参数
+}
+
+// leaseClocks 各数据库的主库时钟
+var leaseClocks = map[string]leaseClock{
+	"mysql": {
+		now:    "UTC_TIMESTAMP(3)",
+		expire: "DATE_ADD(UTC_TIMESTAMP(3), INTERVAL ? MICROSECOND)",
+		ttl:    func(ttl time.Duration) interface{} { return ttl.Microseconds() },
+	},
+	"postgres": {
+		now:    "NOW()",
+		expire: "NOW() + ? * INTERVAL '1 millisecond'",
+		ttl:    func(ttl time.Duration) interface{} { return ttl.Milliseconds() },
+	},
+	"sqlite": {
+		now:    "strftime('%Y-%m-%d %H:%M:%f', 'now')",
+		expire: "strftime('%Y-%m-%d %H:%M:%f', 'now', ?)",
+		ttl:    func(ttl time.Duration) interface{} { return fmt.Sprintf("+%.3f seconds", ttl.Seconds()) },
+	},
+	"sqlserver": {
+		now:    "SYSUTCDATETIME()",
+		expire: "DATEADD(millisecond, ?, SYSUTCDATETIME())",
+		ttl:    func(ttl time.Duration) interface{} { return ttl.Milliseconds() },
+	},
+}
+
+// leaseKeeper 定期续约，续约失败时标记租约丢失
+type leaseKeeper struct {
+	mu      sync.Mutex
+	stop    chan struct{}
+	stopped chan struct{}
+	lost    chan struct{}
+}
+
+// start
+/**
+ *  @Description: 按间隔调用 renew 续约，renew 返回 false 时租约丢失；
+ *  续约失败时在有效期结束前一个续约间隔（ttl - interval）标记租约丢失，早于其他实例接管
+ *  @receiver k
+ *  @param interval
+ *  @param ttl
+ *  @param renew 单次续约的超时时间为 interval
+ */
+func (k *leaseKeeper) start(interval, ttl time.Duration, renew func(ctx context.Context) (ok bool, err error)) {
+	k.mu.Lock()
+	k.stop = make(chan struct{})
+	k.stopped = make(chan struct{})
+	k.lost = make(chan struct{})
+	stop, stopped, lost := k.stop, k.stopped, k.lost
+	k.mu.Unlock()
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		// 租约的过期时间不早于续约开始时间 + ttl
+		lostAt := time.Now().Add(ttl - interval)
+		deadline := time.NewTimer(ttl - interval)
+		defer deadline.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-deadline.C:
+				close(lost)
+				return
+			case <-ticker.C:
+				if !time.Now().Before(lostAt) {
+					close(lost)
+					return
+				}
+				start := time.Now()
+				ctx, cancel := context.WithTimeout(context.Background(), interval)
+				ok, err := renew(ctx)
+				cancel()
+				if err != nil {
+					// 续约失败，在有效期内重试
+					continue
+				}
+				if !ok {
+					// 被其他实例接管
+					close(lost)
+					return
+				}
+				lostAt = start.Add(ttl - interval)
+				if !deadline.Stop() {
+					select {
+					case <-deadline.C:
+					default:
+					}
+				}
+				deadline.Reset(time.Until(lostAt))
+			}
+		}
+	}()
+}
+
+// close
+/**
+ *  @Description: 停止续约并关闭租约丢失通知，使用该节点的生成器此后返回 ErrLeaseLost
+ *  @receiver k
+ */
+func (k *leaseKeeper) close() {
+	k.mu.Lock()
+	stop, stopped, lost := k.stop, k.stopped, k.lost
+	k.stop = nil
+	k.mu.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-stopped
+	// 续约已停止，仅在此处或续约中关闭
+	select {
+	case <-lost:
+	default:
+		close(lost)
+	}
+}
+
+// lostChan
+/**
+ *  @Description: 获取租约丢失通知，未申请节点时返回 nil
+ *  @receiver k
+ *  @return <-chan struct{}
+ */
+func (k *leaseKeeper) lostChan() <-chan struct{} {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.lost
+}
+
+// LeaseAllocator 基于主库租约表的节点分配
+type LeaseAllocator struct {
+	db    *gorm.DB
+	opts  LeaseOptions
+	clock leaseClock
+	node  int64
+	leaseKeeper
+}
+
+// NewLeaseAllocator
+/**
+ *  @Description: 创建基于租约表的节点分配，并在主库中创建租约表
+ *  @param db 主库
+ *  @param opts
+ *  @return allocator
+ *  @return err
+ */
+func NewLeaseAllocator(db *gorm.DB, opts LeaseOptions) (allocator *LeaseAllocator, err error) {
+	if db == nil {
+		err = errors.New("节点租约须指定主库")
+		return
+	}
+	clock, ok := leaseClocks[db.Dialector.Name()]
+	if !ok {
+		err = errors.New("节点租约不支持数据库 " + db.Dialector.Name())
+		return
+	}
+	err = db.AutoMigrate(&NodeLease{})
+	if err != nil {
+		return
+	}
+	allocator = &LeaseAllocator{
+		db:    db,
+		opts:  opts.withDefault(),
+		clock: clock,
+		node:  -1,
+	}
+	return
+}
+
+// Acquire
+/**
+ *  @Description: 申请未被占用或租约已过期的节点
+ *  @receiver a
+ *  @param ctx
+ *  @return node
+ *  @return err
+ */
+func (a *LeaseAllocator) Acquire(ctx context.Context) (node int64, err error) {
+	if a.node >= 0 {
+		err = errors.New("已申请节点 " + strconv.FormatInt(a.node, 10))
+		return
+	}
+	tx := a.db.WithContext(ctx)
+	for node = 0; node <= a.opts.maxNode(); node++ {
+		lease := map[string]interface{}{
+			"namespace":   a.opts.Namespace,
+			"node":        node,
+			"owner":       a.opts.Owner,
+			"expire_time": a.expireTime(),
+		}
+		// 主键冲突时不插入，其他异常（如连接失败）直接返回，避免误判为节点已被占用
+		result := tx.Model(&NodeLease{}).Clauses(clause.OnConflict{DoNothing: true}).Create(lease)
+		if result.Error != nil {
+			err = result.Error
+			return
+		}
+		if result.RowsAffected == 0 {
+			// 节点已被占用，接管租约已过期的节点
+			result = tx.Model(&NodeLease{}).
+				Where("namespace = ? AND node = ? AND expire_time < "+a.clock.now, a.opts.Namespace, node).
+				Updates(map[string]interface{}{"owner": a.opts.Owner, "expire_time": a.expireTime()})
+			if result.Error != nil {
+				err = result.Error
+				return
+			}
+			if result.RowsAffected == 0 {
+				continue
+			}
+		}
+		a.node = node
+		a.start(a.opts.Heartbeat, a.opts.TTL, a.renew)
+		return
+	}
+	err = errors.New("命名空间 " + a.opts.Namespace + " 没有空闲节点")
+	return
+}
+
+// renew
+/**
+ *  @Description: 续约
+ *  @receiver a
+ *  @param ctx
+ *  @return ok 租约仍属于当前实例
+ *  @return err
+ */
+func (a *LeaseAllocator) renew(ctx context.Context) (ok bool, err error) {
+	result := a.db.WithContext(ctx).Model(&NodeLease{}).
+		Where("namespace = ? AND node = ? AND owner = ?", a.opts.Namespace, a.node, a.opts.Owner).
+		Update("expire_time", a.expireTime())
+	return result.RowsAffected == 1, result.Error
+}
+
+// expireTime
+/**
+ *  @Description: 由主库时钟计算的过期时间
+ *  @receiver a
+ *  @return clause.Expr
+ */
+func (a *LeaseAllocator) expireTime() clause.Expr {
+	return gorm.Expr(a.clock.expire, a.clock.ttl(a.opts.TTL))
+}
+
+// LimitNode
+/**
+ *  @Description: 申请的节点不超过 maxNode
+ *  @receiver a
+ *  @param maxNode
+ */
+func (a *LeaseAllocator) LimitNode(maxNode int64) {
+	a.opts.limitNode(maxNode)
+	return
+}
+
+// Release
+/**
+ *  @Description: 停止续约并删除租约，Lost 随之关闭
+ *  @receiver a
+ *  @param ctx
+ *  @return err
+ */
+func (a *LeaseAllocator) Release(ctx context.Context) (err error) {
+	if a.node < 0 {
+		return
+	}
+	a.close()
+	err = a.db.WithContext(ctx).
+		Where("namespace = ? AND node = ? AND owner = ?", a.opts.Namespace, a.node, a.opts.Owner).
+		Delete(&NodeLease{}).Error
+	if err != nil {
+		return
+	}
+	a.node = -1
+	return
+}
+
+// Lost
+/**
+ *  @Description: 租约丢失通知
+ *  @receiver a
+ *  @return <-chan struct{}
+ */
+func (a *LeaseAllocator) Lost() <-chan struct{} {
+	return a.lostChan()
+}
+
+// InitLeasedIdGenerator
+/**
+ *  @Description: 通过节点分配申请节点并初始化分布式ID生成器，租约丢失或释放节点后生成器返回 ErrLeaseLost，实例关闭时须调用 allocator.Release
+ *  @param ctx
+ *  @param tenantId
+ *  @param tenantNo 租户编号，ID结构不包含租户编号时忽略
+ *  @param layout ID结构，节点分配实现 NodeLimiter 时按其最大节点限制申请范围
+ *  @param allocator
+ *  @return generator
+ *  @return err
+ */
+func InitLeasedIdGenerator(ctx context.Context, tenantId string, tenantNo int64, layout Layout, allocator NodeAllocator) (generator *DistributedIdGenerator, err error) {
+	if limiter, ok := allocator.(NodeLimiter); ok {
+		limiter.LimitNode(layout.MaxNode())
+	}
+	node, err := allocator.Acquire(ctx)
+	if err != nil {
+		return
+	}
+	// 未实现 NodeLimiter 的节点分配可能申请到超出范围的节点，由 NewDistributedIdGenerator 校验
+	generator, err = NewDistributedIdGenerator(tenantId, tenantNo, node, layout)
+	if err != nil {
+		_ = allocator.Release(ctx)
+		return
+	}
+	generator.lost = allocator.Lost()
+	return
+}
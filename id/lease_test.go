@@ -0,0 +1,67 @@
+/**
+ * @Time    :2023/7/27 15:40
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package id
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLeasedIdGenerator(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	// 1位节点，最多2个节点
+	layout := Layout{Epoch: DefaultLayout.Epoch, NodeBits: 1, StepBits: 12}
+	var allocators []*FileLockAllocator
+	var generators []*DistributedIdGenerator
+	for i := 0; i < 2; i++ {
+		allocator, err := NewFileLockAllocator(dir, LeaseOptions{Namespace: "lease-test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		generator, err := InitLeasedIdGenerator(ctx, "", 0, layout, allocator)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if generator.Layout() != layout || layout.Node(generator.CreateId()) != int64(i) {
+			t.Errorf("generator %d: layout %+v, node %d", i, generator.Layout(), layout.Node(generator.CreateId()))
+		}
+		allocators = append(allocators, allocator)
+		generators = append(generators, generator)
+	}
+	defer func() {
+		for _, allocator := range allocators {
+			_ = allocator.Release(ctx)
+		}
+	}()
+	allocator, err := NewFileLockAllocator(dir, LeaseOptions{Namespace: "lease-test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = InitLeasedIdGenerator(ctx, "", 0, layout, allocator); err == nil {
+		t.Error("node beyond layout: expected error")
+	}
+
+	// 释放节点后生成器拒绝生成ID
+	lost := allocators[0].Lost()
+	if err = allocators[0].Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-lost:
+	default:
+		t.Error("lost not closed after release")
+	}
+	if _, err = generators[0].CreateIdE(); err != ErrLeaseLost {
+		t.Errorf("CreateIdE after release: err = %v", err)
+	}
+	if _, err = generators[1].CreateIdE(); err != nil {
+		t.Errorf("CreateIdE: %v", err)
+	}
+	if err = allocators[0].Release(ctx); err != nil {
+		t.Errorf("release twice: %v", err)
+	}
+}
@@ -5,8 +5,28 @@
 
 package id
 
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// Model 创建时间、更新时间以字符串（TimeLayout）保存，注册多租户插件后自动维护
 type Model struct {
 	ID         DistributedId `json:"id"                     form:"id"     gorm:"column:id;primary_key;type:bigint"`
 	CreateTime string        `json:"createTime"             form:"-"       gorm:"column:create_time;index;type:varchar(20)"`
 	UpdateTime string        `json:"updateTime,omitempty"   form:"-"       gorm:"column:update_time;type:varchar(20)"`
 }
+
+// SoftDeleteModel 支持软删除的 Model，删除时间以字符串（TimeLayout）保存
+type SoftDeleteModel struct {
+	Model
+	DeleteTime DeleteTime `json:"deleteTime,omitempty"   form:"-"       gorm:"column:delete_time;index;type:varchar(20)"`
+}
+
+// TimeModel 创建时间、更新时间、删除时间以 DATETIME 保存，由 GORM 维护，删除时间与 gorm.DeletedAt 一致
+type TimeModel struct {
+	ID         DistributedId  `json:"id"                     form:"id"     gorm:"column:id;primary_key;type:bigint"`
+	CreateTime time.Time      `json:"createTime"             form:"-"       gorm:"column:create_time;index;autoCreateTime"`
+	UpdateTime time.Time      `json:"updateTime"             form:"-"       gorm:"column:update_time;autoUpdateTime"`
+	DeleteTime gorm.DeletedAt `json:"deleteTime,omitempty"   form:"-"       gorm:"column:delete_time;index"`
+}
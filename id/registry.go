@@ -0,0 +1,162 @@
+/**
+ * @Time    :2023/7/26 10:05
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package id
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+)
+
+// generatorRegistry 按租户注册的ID生成器
+var generatorRegistry = struct {
+	sync.RWMutex
+	generators map[string]*DistributedIdGenerator // 租户ID -> 生成器
+	tenants    map[Layout]map[int64]string        // ID结构 -> 租户编号 -> 租户ID
+	layouts    map[string]Layout                  // 名称 -> 包含租户编号的ID结构
+}{
+	generators: make(map[string]*DistributedIdGenerator),
+	tenants:    make(map[Layout]map[int64]string),
+	layouts:    map[string]Layout{LayoutNameTenant: TenantLayout},
+}
+
+// LayoutNameTenant TenantLayout 的注册名称
+const LayoutNameTenant = "tenant"
+
+// RegisterLayout
+/**
+ *  @Description: 按名称注册包含租户编号的ID结构，模型主键可通过 mt:"layout:名称" 声明，由ID中的租户编号选择数据库；须在使用模型前注册
+ *  @param name
+ *  @param layout
+ *  @return err 名称为空或已注册其他ID结构、ID结构不包含租户编号或校验失败
+ */
+func RegisterLayout(name string, layout Layout) (err error) {
+	if name == "" {
+		err = errors.New("ID结构名称不能为空")
+		return
+	}
+	if layout.TenantBits == 0 {
+		err = errors.New("ID结构 " + name + " 须包含租户编号")
+		return
+	}
+	err = layout.Validate()
+	if err != nil {
+		return
+	}
+	generatorRegistry.Lock()
+	defer generatorRegistry.Unlock()
+	if registered, ok := generatorRegistry.layouts[name]; ok && registered != layout {
+		err = errors.New("ID结构 " + name + " 已注册")
+		return
+	}
+	generatorRegistry.layouts[name] = layout
+	return
+}
+
+// LookupLayout
+/**
+ *  @Description: 获取已注册的ID结构，内置 LayoutNameTenant（TenantLayout）
+ *  @param name
+ *  @return layout
+ *  @return ok
+ */
+func LookupLayout(name string) (layout Layout, ok bool) {
+	generatorRegistry.RLock()
+	defer generatorRegistry.RUnlock()
+	layout, ok = generatorRegistry.layouts[name]
+	return
+}
+
+// RegisterGenerator
+/**
+ *  @Description: 按生成器的租户ID注册ID生成器，租户ID为空的生成器作为默认生成器；
+ *  ID结构包含租户编号时按ID结构记录租户编号，可通过 Layout.TenantOf 由该结构生成的ID获取租户
+ *  @param generator
+ *  @return err 租户编号已被相同ID结构的其他租户使用
+ */
+func RegisterGenerator(generator *DistributedIdGenerator) (err error) {
+	if generator == nil {
+		err = errors.New("ID生成器不能为空")
+		return
+	}
+	generatorRegistry.Lock()
+	defer generatorRegistry.Unlock()
+	if generator.layout.TenantBits > 0 {
+		tenants, ok := generatorRegistry.tenants[generator.layout]
+		if !ok {
+			tenants = make(map[int64]string)
+			generatorRegistry.tenants[generator.layout] = tenants
+		}
+		if tenantId, ok := tenants[generator.TenantNo]; ok && tenantId != generator.TenantId {
+			err = errors.New("租户编号 " + strconv.FormatInt(generator.TenantNo, 10) + " 已被租户 " + tenantId + " 使用")
+			return
+		}
+		tenants[generator.TenantNo] = generator.TenantId
+	}
+	generatorRegistry.generators[generator.TenantId] = generator
+	return
+}
+
+// GeneratorFor
+/**
+ *  @Description: 获取租户的ID生成器，未注册时使用默认生成器，可直接用于 MultiTenancy.SetIdGeneratorFunc
+ *  @param tenantId
+ *  @return generator
+ *  @return err
+ */
+func GeneratorFor(tenantId string) (generator *DistributedIdGenerator, err error) {
+	generatorRegistry.RLock()
+	defer generatorRegistry.RUnlock()
+	generator, ok := generatorRegistry.generators[tenantId]
+	if ok {
+		return
+	}
+	generator, ok = generatorRegistry.generators[""]
+	if ok {
+		return
+	}
+	err = errors.New("租户 " + tenantId + " 未注册ID生成器")
+	return
+}
+
+// TenantOf
+/**
+ *  @Description: 按ID结构获取ID中的租户编号对应的租户ID，ID须由该结构的生成器生成
+ *  @receiver layout
+ *  @param id
+ *  @return tenantId
+ *  @return ok ID结构不包含租户编号或租户编号未注册时为 false
+ */
+func (layout Layout) TenantOf(id DistributedId) (tenantId string, ok bool) {
+	if layout.TenantBits == 0 {
+		return
+	}
+	generatorRegistry.RLock()
+	defer generatorRegistry.RUnlock()
+	tenantId, ok = generatorRegistry.tenants[layout][layout.TenantNo(id)]
+	return
+}
+
+// TenantOf
+/**
+ *  @Description: 由包含租户编号的ID获取租户ID，仅适用于按 TenantLayout 生成的ID，其他ID结构使用 Layout.TenantOf
+ *  @param id
+ *  @return tenantId
+ *  @return ok 租户编号未注册时为 false
+ */
+func TenantOf(id DistributedId) (tenantId string, ok bool) {
+	return TenantLayout.TenantOf(id)
+}
+
+// TenantNo
+/**
+ *  @Description: 按 TenantLayout 获取ID中的租户编号，其他ID结构使用 Layout.TenantNo
+ *  @receiver t
+ *  @return int64
+ */
+func (t DistributedId) TenantNo() int64 {
+	return TenantLayout.TenantNo(t)
+}
@@ -0,0 +1,87 @@
+/**
+ * @Time    :2023/7/26 11:30
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package id
+
+import "testing"
+
+func TestRegisterLayout(t *testing.T) {
+	if layout, ok := LookupLayout(LayoutNameTenant); !ok || layout != TenantLayout {
+		t.Fatalf("builtin layout = %+v, %v", layout, ok)
+	}
+	wide := Layout{Epoch: TenantLayout.Epoch, TenantBits: 10, NodeBits: 4, StepBits: 8}
+	if err := RegisterLayout("wide", wide); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterLayout("wide", wide); err != nil {
+		t.Errorf("re-register same layout: %v", err)
+	}
+	tests := []struct {
+		name   string
+		layout Layout
+	}{
+		{name: "", layout: wide},
+		{name: "plain", layout: DefaultLayout},
+		{name: "wide", layout: TenantLayout},
+		{name: "invalid", layout: Layout{Epoch: TenantLayout.Epoch, TenantBits: 8}},
+	}
+	for _, tt := range tests {
+		if err := RegisterLayout(tt.name, tt.layout); err == nil {
+			t.Errorf("RegisterLayout(%q, %+v): expected error", tt.name, tt.layout)
+		}
+	}
+}
+
+func TestTenantOfLayout(t *testing.T) {
+	wide := Layout{Epoch: TenantLayout.Epoch, TenantBits: 12, NodeBits: 2, StepBits: 8}
+	wideGenerator, err := NewDistributedIdGenerator("registry-wide", 3000, 1, wide)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenantGenerator, err := NewDistributedIdGenerator("registry-tenant", 200, 1, TenantLayout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, generator := range []*DistributedIdGenerator{wideGenerator, tenantGenerator} {
+		if err = RegisterGenerator(generator); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wideId := wideGenerator.CreateId()
+	if tenantId, ok := wide.TenantOf(wideId); !ok || tenantId != "registry-wide" {
+		t.Errorf("wide.TenantOf = %q, %v", tenantId, ok)
+	}
+	if wide.TenantNo(wideId) != 3000 {
+		t.Errorf("wide.TenantNo = %d", wide.TenantNo(wideId))
+	}
+	tenantId := tenantGenerator.CreateId()
+	if got, ok := TenantOf(tenantId); !ok || got != "registry-tenant" {
+		t.Errorf("TenantOf = %q, %v", got, ok)
+	}
+	// 租户编号按ID结构区分，其他结构的ID不会解析为该结构的租户
+	if got, ok := wide.TenantOf(tenantId); ok && got == "registry-tenant" {
+		t.Errorf("wide.TenantOf(tenant layout id) = %q", got)
+	}
+	if _, ok := DefaultLayout.TenantOf(wideId); ok {
+		t.Error("DefaultLayout.TenantOf: expected false")
+	}
+
+	// 相同ID结构的租户编号唯一，不同ID结构可重复
+	duplicate, err := NewDistributedIdGenerator("registry-other", 3000, 1, wide)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = RegisterGenerator(duplicate); err == nil {
+		t.Error("duplicate tenant no: expected error")
+	}
+	narrow := Layout{Epoch: TenantLayout.Epoch, TenantBits: 12, NodeBits: 3, StepBits: 8}
+	other, err := NewDistributedIdGenerator("registry-other", 3000, 1, narrow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = RegisterGenerator(other); err != nil {
+		t.Errorf("same tenant no in other layout: %v", err)
+	}
+}
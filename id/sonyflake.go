@@ -0,0 +1,161 @@
+/**
+ * @Time    :2023/7/31 15:10
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package id
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	sonyflakeTimeBits     = 39
+	sonyflakeSequenceBits = 8
+	sonyflakeMachineBits  = 16
+	// 时间戳单位
+	sonyflakeTimeUnit = 10 * time.Millisecond
+)
+
+// DefaultSonyflakeEpoch Sonyflake 的默认起始时间
+var DefaultSonyflakeEpoch = time.Date(2014, 9, 1, 0, 0, 0, 0, time.UTC)
+
+// SonyflakeGenerator Sonyflake 生成器：39位时间戳（10毫秒）| 8位序列号 | 16位机器号，
+// 可使用约174年、支持 65536 台机器，每台机器每10毫秒生成256个ID
+type SonyflakeGenerator struct {
+	mu      sync.Mutex
+	clock   Clock
+	epoch   time.Time
+	machine int64
+	time    int64 // 上次生成ID的时间戳（自起始时间起的10毫秒数）
+	step    int64
+}
+
+// NewSonyflakeGenerator
+/**
+ *  @Description: 初始化 Sonyflake 生成器
+ *  @param machine 机器号，各实例唯一
+ *  @param epoch 起始时间，为零值时使用 DefaultSonyflakeEpoch
+ *  @return generator
+ *  @return err 起始时间晚于当前时间
+ */
+func NewSonyflakeGenerator(machine uint16, epoch time.Time) (generator *SonyflakeGenerator, err error) {
+	if epoch.IsZero() {
+		epoch = DefaultSonyflakeEpoch
+	}
+	if epoch.After(time.Now()) {
+		err = errors.New("起始时间不能晚于当前时间")
+		return
+	}
+	generator = &SonyflakeGenerator{
+		clock:   systemClock{},
+		epoch:   epoch,
+		machine: int64(machine),
+	}
+	return
+}
+
+// SetClock
+/**
+ *  @Description: 设置时钟，用于测试时注入可控时钟
+ *  @receiver generator
+ *  @param clock
+ */
+func (generator *SonyflakeGenerator) SetClock(clock Clock) {
+	generator.mu.Lock()
+	defer generator.mu.Unlock()
+	if clock == nil {
+		clock = systemClock{}
+	}
+	generator.clock = clock
+}
+
+// elapsed
+/**
+ *  @Description: 自起始时间起的10毫秒数
+ *  @receiver generator
+ *  @return int64
+ */
+func (generator *SonyflakeGenerator) elapsed() int64 {
+	return int64(generator.clock.Now().Sub(generator.epoch) / sonyflakeTimeUnit)
+}
+
+// CreateId
+/**
+ *  @Description: 创建一个 Sonyflake ID，序列号用完或时钟回拨时沿用上次的时间戳加1，并等待时钟追上以保持递增
+ *  @receiver generator
+ *  @return id
+ *  @return err 时间超出39位时间戳时返回 ErrTimeOverflow
+ */
+func (generator *SonyflakeGenerator) CreateId() (id DistributedId, err error) {
+	generator.mu.Lock()
+	defer generator.mu.Unlock()
+	now := generator.elapsed()
+	if now > generator.time {
+		generator.time = now
+		generator.step = 0
+	} else {
+		generator.step = (generator.step + 1) & (1<<sonyflakeSequenceBits - 1)
+		if generator.step == 0 {
+			generator.time++
+			// 等待至借用的时间戳
+			generator.clock.Sleep(generator.epoch.Add(time.Duration(generator.time) * sonyflakeTimeUnit).Sub(generator.clock.Now()))
+		}
+	}
+	if generator.time >= 1<<sonyflakeTimeBits {
+		err = ErrTimeOverflow
+		return
+	}
+	id = DistributedId(generator.time<<(sonyflakeSequenceBits+sonyflakeMachineBits) |
+		generator.step<<sonyflakeMachineBits |
+		generator.machine)
+	return
+}
+
+// NewID
+/**
+ *  @Description: 实现 Generator，同 CreateId
+ *  @receiver generator
+ *  @return ID
+ *  @return error
+ */
+func (generator *SonyflakeGenerator) NewID() (ID, error) {
+	id, err := generator.CreateId()
+	if err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// Time
+/**
+ *  @Description: 获取 Sonyflake ID 的生成时间（10毫秒精度），ID须由起始时间相同的生成器生成
+ *  @receiver generator
+ *  @param id
+ *  @return time.Time
+ */
+func (generator *SonyflakeGenerator) Time(id DistributedId) time.Time {
+	return generator.epoch.Add(time.Duration(int64(id)>>(sonyflakeSequenceBits+sonyflakeMachineBits)) * sonyflakeTimeUnit)
+}
+
+// SonyflakeMachine
+/**
+ *  @Description: 获取 Sonyflake ID 中的机器号
+ *  @param id
+ *  @return uint16
+ */
+func SonyflakeMachine(id DistributedId) uint16 {
+	return uint16(int64(id) & (1<<sonyflakeMachineBits - 1))
+}
+
+// SonyflakeSequence
+/**
+ *  @Description: 获取 Sonyflake ID 中的序列号
+ *  @param id
+ *  @return int64
+ */
+func SonyflakeSequence(id DistributedId) int64 {
+	return int64(id) >> sonyflakeMachineBits & (1<<sonyflakeSequenceBits - 1)
+}
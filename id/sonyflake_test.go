@@ -0,0 +1,56 @@
+/**
+ * @Time    :2023/7/31 16:30
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package id
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSonyflakeGenerator(t *testing.T) {
+	generator, err := NewSonyflakeGenerator(513, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2023, 7, 31, 16, 30, 0, 0, time.UTC)
+	clock := &manualClock{now: start}
+	generator.SetClock(clock)
+	var last DistributedId
+	for i := 0; i < 1000; i++ {
+		if i == 700 {
+			clock.now = clock.now.Add(-time.Second)
+		}
+		id, err := generator.CreateId()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id <= last {
+			t.Fatalf("%d: %d <= %d", i, id, last)
+		}
+		if SonyflakeMachine(id) != 513 {
+			t.Fatalf("machine = %d", SonyflakeMachine(id))
+		}
+		last = id
+	}
+	// 每10毫秒256个ID，序列号用完时等待下一个10毫秒
+	if got := generator.Time(last); !got.Equal(start.Add(30 * time.Millisecond)) {
+		t.Errorf("time = %v", got)
+	}
+	if SonyflakeSequence(last) != 1000-3*256-1 {
+		t.Errorf("sequence = %d", SonyflakeSequence(last))
+	}
+	if !clock.now.After(start) {
+		t.Errorf("clock not advanced: %v", clock.now)
+	}
+
+	clock.now = DefaultSonyflakeEpoch.Add(175 * 365 * 24 * time.Hour)
+	if _, err = generator.CreateId(); err != ErrTimeOverflow {
+		t.Errorf("overflow: err = %v", err)
+	}
+	if _, err = NewSonyflakeGenerator(1, time.Now().Add(time.Hour)); err == nil {
+		t.Error("future epoch: expected error")
+	}
+}
@@ -0,0 +1,146 @@
+/**
+ * @Time    :2023/7/25 15:30
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package id
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+)
+
+const (
+	// DefaultTimeLayout 字符串时间字段（CreateTime、UpdateTime、DeleteTime）的默认格式，时间取自 gorm.Config 的 NowFunc
+	DefaultTimeLayout = "2006-01-02 15:04:05"
+	// TimeLayoutKey 语句设置中时间格式的键，可通过 db.Set(id.TimeLayoutKey, layout) 为会话指定格式
+	TimeLayoutKey = "multi-tenancy:time_layout"
+)
+
+// TimeLayout
+/**
+ *  @Description: 获取语句的字符串时间格式，未通过 TimeLayoutKey 设置时为 DefaultTimeLayout
+ *  @param stmt
+ *  @return layout
+ */
+func TimeLayout(stmt *gorm.Statement) (layout string) {
+	if value, ok := stmt.Settings.Load(TimeLayoutKey); ok {
+		if layout, ok = value.(string); ok && layout != "" {
+			return
+		}
+	}
+	return DefaultTimeLayout
+}
+
+// DeleteTime 以字符串保存的软删除时间，与 gorm.DeletedAt 的语义一致：删除时记录删除时间，查询、更新时排除已删除的数据，Unscoped 时不生效
+type DeleteTime sql.NullString
+
+// Scan 将数据库中取出的数据，赋值给目标类型
+func (t *DeleteTime) Scan(value interface{}) error {
+	return (*sql.NullString)(t).Scan(value)
+}
+
+// Value 写入数据库之前，对数据做类型转换
+func (t DeleteTime) Value() (driver.Value, error) {
+	if !t.Valid {
+		return nil, nil
+	}
+	return t.String, nil
+}
+
+// MarshalJSON
+/**
+ *  @Description: 未删除时为 null
+ *  @receiver t
+ *  @return []byte
+ *  @return error
+ */
+func (t DeleteTime) MarshalJSON() ([]byte, error) {
+	if t.Valid {
+		return json.Marshal(t.String)
+	}
+	return json.Marshal(nil)
+}
+
+// UnmarshalJSON
+/**
+ *  @Description: null 为未删除
+ *  @receiver t
+ *  @param b
+ *  @return error
+ */
+func (t *DeleteTime) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		t.Valid = false
+		return nil
+	}
+	err := json.Unmarshal(b, &t.String)
+	if err == nil {
+		t.Valid = true
+	}
+	return err
+}
+
+func (DeleteTime) QueryClauses(f *schema.Field) []clause.Interface {
+	return []clause.Interface{gorm.SoftDeleteQueryClause{Field: f}}
+}
+
+func (DeleteTime) UpdateClauses(f *schema.Field) []clause.Interface {
+	return []clause.Interface{gorm.SoftDeleteUpdateClause{Field: f}}
+}
+
+func (DeleteTime) DeleteClauses(f *schema.Field) []clause.Interface {
+	return []clause.Interface{softDeleteClause{Field: f}}
+}
+
+// softDeleteClause 删除时将 DeleteTime 设置为当前时间
+type softDeleteClause struct {
+	Field *schema.Field
+}
+
+func (sd softDeleteClause) Name() string {
+	return ""
+}
+
+func (sd softDeleteClause) Build(clause.Builder) {
+}
+
+func (sd softDeleteClause) MergeClause(*clause.Clause) {
+}
+
+// ModifyStatement
+/**
+ *  @Description: 将 DELETE 改写为 UPDATE，按主键及查询条件更新删除时间
+ *  @receiver sd
+ *  @param stmt
+ */
+func (sd softDeleteClause) ModifyStatement(stmt *gorm.Statement) {
+	if stmt.SQL.Len() != 0 || stmt.Statement.Unscoped {
+		return
+	}
+	curTime := stmt.DB.NowFunc().Format(TimeLayout(stmt))
+	stmt.AddClause(clause.Set{{Column: clause.Column{Name: sd.Field.DBName}, Value: curTime}})
+	stmt.SetColumn(sd.Field.DBName, DeleteTime{String: curTime, Valid: true}, true)
+	if stmt.Schema != nil {
+		_, queryValues := schema.GetIdentityFieldValuesMap(stmt.Context, stmt.ReflectValue, stmt.Schema.PrimaryFields)
+		column, values := schema.ToQueryValues(stmt.Table, stmt.Schema.PrimaryFieldDBNames, queryValues)
+		if len(values) > 0 {
+			stmt.AddClause(clause.Where{Exprs: []clause.Expression{clause.IN{Column: column, Values: values}}})
+		}
+		if stmt.ReflectValue.CanAddr() && stmt.Dest != stmt.Model && stmt.Model != nil {
+			_, queryValues = schema.GetIdentityFieldValuesMap(stmt.Context, reflect.ValueOf(stmt.Model), stmt.Schema.PrimaryFields)
+			column, values = schema.ToQueryValues(stmt.Table, stmt.Schema.PrimaryFieldDBNames, queryValues)
+			if len(values) > 0 {
+				stmt.AddClause(clause.Where{Exprs: []clause.Expression{clause.IN{Column: column, Values: values}}})
+			}
+		}
+	}
+	gorm.SoftDeleteQueryClause{Field: sd.Field}.ModifyStatement(stmt)
+	stmt.AddClauseIfNotExists(clause.Update{})
+	stmt.Build(stmt.DB.Callback().Update().Clauses...)
+}
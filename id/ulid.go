@@ -0,0 +1,243 @@
+/**
+ * @Time    :2023/7/31 10:45
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package id
+
+import (
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ULID 可排序的128位ID：48位 Unix 毫秒时间戳 | 80位随机数，文本为26位 Crockford Base32
+type ULID [16]byte
+
+// ULID 字符集
+const ulidAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ulidDecoding 字符 -> 值，大小写不敏感，I、L 视为 1，O 视为 0
+var ulidDecoding = func() (dec [256]byte) {
+	for i := range dec {
+		dec[i] = 0xFF
+	}
+	for i := 0; i < len(ulidAlphabet); i++ {
+		dec[ulidAlphabet[i]] = byte(i)
+		dec[ulidAlphabet[i]|0x20] = byte(i)
+	}
+	dec['I'], dec['i'], dec['L'], dec['l'] = 1, 1, 1, 1
+	dec['O'], dec['o'] = 0, 0
+	return
+}()
+
+// ParseULID
+/**
+ *  @Description: 解析26位 Crockford Base32 文本
+ *  @param s
+ *  @return u
+ *  @return err
+ */
+func ParseULID(s string) (u ULID, err error) {
+	if len(s) != 26 {
+		err = errors.New("无效的ULID：" + strconv.Quote(s))
+		return
+	}
+	var hi, lo uint64
+	for i := 0; i < len(s); i++ {
+		v := ulidDecoding[s[i]]
+		// 首位最大为7，否则超出128位
+		if v == 0xFF || (i == 0 && v > 7) {
+			err = errors.New("无效的ULID：" + strconv.Quote(s))
+			return
+		}
+		hi = hi<<5 | lo>>59
+		lo = lo<<5 | uint64(v)
+	}
+	binary.BigEndian.PutUint64(u[:8], hi)
+	binary.BigEndian.PutUint64(u[8:], lo)
+	return
+}
+
+// String
+/**
+ *  @Description: 转换为26位 Crockford Base32 文本
+ *  @receiver u
+ *  @return string
+ */
+func (u ULID) String() string {
+	hi, lo := binary.BigEndian.Uint64(u[:8]), binary.BigEndian.Uint64(u[8:])
+	var b [26]byte
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i] = ulidAlphabet[lo&0x1F]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(b[:])
+}
+
+// Time
+/**
+ *  @Description: 获取ULID的生成时间（毫秒精度）
+ *  @receiver u
+ *  @return time.Time
+ */
+func (u ULID) Time() time.Time {
+	return time.UnixMilli(int64(binary.BigEndian.Uint64(u[:8]) >> 16))
+}
+
+// IsZero
+/**
+ *  @Description: 是否为零值
+ *  @receiver u
+ *  @return bool
+ */
+func (u ULID) IsZero() bool {
+	return u == ULID{}
+}
+
+// MarshalText 实现 encoding.TextMarshaler
+func (u ULID) MarshalText() ([]byte, error) {
+	return []byte(u.String()), nil
+}
+
+// UnmarshalText 实现 encoding.TextUnmarshaler，空字符串解析为零值
+func (u *ULID) UnmarshalText(b []byte) (err error) {
+	if len(b) == 0 {
+		*u = ULID{}
+		return
+	}
+	*u, err = ParseULID(string(b))
+	return
+}
+
+// MarshalJSON 输出为字符串
+func (u ULID) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + u.String() + `"`), nil
+}
+
+// UnmarshalJSON 解析字符串，null 时保持不变
+func (u *ULID) UnmarshalJSON(b []byte) error {
+	str, ok := unquoteJSON(b)
+	if !ok {
+		return errors.New("无效的ULID：" + string(b))
+	}
+	if str == nil {
+		return nil
+	}
+	return u.UnmarshalText(str)
+}
+
+// GormDataType 字段类型
+func (ULID) GormDataType() string {
+	return "char(26)"
+}
+
+// Value 写入数据库之前，对数据做类型转换
+func (u ULID) Value() (driver.Value, error) {
+	return u.String(), nil
+}
+
+// Scan 将数据库中取出的数据，赋值给目标类型，支持文本及16字节二进制，NULL 为零值
+func (u *ULID) Scan(v interface{}) error {
+	switch val := v.(type) {
+	case nil:
+		*u = ULID{}
+	case string:
+		return u.UnmarshalText([]byte(val))
+	case []byte:
+		if len(val) == len(u) {
+			copy(u[:], val)
+			return nil
+		}
+		return u.UnmarshalText(val)
+	default:
+		return errors.New(fmt.Sprintf("%T", v) + "类型处理错误")
+	}
+	return nil
+}
+
+// ULIDGenerator ULID生成器，同一毫秒内生成的ULID单调递增
+type ULIDGenerator struct {
+	mu    sync.Mutex
+	clock Clock
+	time  int64
+	last  ULID
+}
+
+// NewULIDGenerator
+/**
+ *  @Description: 初始化ULID生成器
+ *  @return *ULIDGenerator
+ */
+func NewULIDGenerator() *ULIDGenerator {
+	return &ULIDGenerator{clock: systemClock{}}
+}
+
+// SetClock
+/**
+ *  @Description: 设置时钟，用于测试时注入可控时钟
+ *  @receiver generator
+ *  @param clock
+ */
+func (generator *ULIDGenerator) SetClock(clock Clock) {
+	generator.mu.Lock()
+	defer generator.mu.Unlock()
+	if clock == nil {
+		clock = systemClock{}
+	}
+	generator.clock = clock
+}
+
+// CreateULID
+/**
+ *  @Description: 创建一个ULID，时钟回拨时沿用上次的时间戳以保持递增
+ *  @receiver generator
+ *  @return u
+ *  @return err 同一毫秒内随机数溢出
+ */
+func (generator *ULIDGenerator) CreateULID() (u ULID, err error) {
+	generator.mu.Lock()
+	defer generator.mu.Unlock()
+	now := unixMilli(generator.clock)
+	if now <= generator.time {
+		// 同一毫秒内，随机数部分加1
+		u = generator.last
+		for i := len(u) - 1; i >= 6; i-- {
+			u[i]++
+			if u[i] != 0 {
+				generator.last = u
+				return
+			}
+		}
+		err = errors.New("同一毫秒内生成的ULID过多")
+		return
+	}
+	binary.BigEndian.PutUint16(u[:2], uint16(now>>32))
+	binary.BigEndian.PutUint32(u[2:6], uint32(now))
+	err = randomBytes(u[6:])
+	if err != nil {
+		return
+	}
+	generator.time, generator.last = now, u
+	return
+}
+
+// NewID
+/**
+ *  @Description: 实现 Generator，同 CreateULID
+ *  @receiver generator
+ *  @return ID
+ *  @return error
+ */
+func (generator *ULIDGenerator) NewID() (ID, error) {
+	u, err := generator.CreateULID()
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
@@ -0,0 +1,92 @@
+/**
+ * @Time    :2023/7/31 16:10
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package id
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestULIDRoundTrip(t *testing.T) {
+	generator := NewULIDGenerator()
+	u, err := generator.CreateULID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseULID(u.String())
+	if err != nil || parsed != u {
+		t.Fatalf("ParseULID(%s) = %v, %v", u, parsed, err)
+	}
+	lower, err := ParseULID(toLower(u.String()))
+	if err != nil || lower != u {
+		t.Errorf("lower case: %v, %v", lower, err)
+	}
+	b, err := json.Marshal(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded ULID
+	if err = json.Unmarshal(b, &decoded); err != nil || decoded != u {
+		t.Errorf("json: %v, %v", decoded, err)
+	}
+	value, err := u.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var scanned, scannedBytes ULID
+	if err = scanned.Scan(value); err != nil || scanned != u {
+		t.Errorf("scan text: %v, %v", scanned, err)
+	}
+	if err = scannedBytes.Scan(u[:]); err != nil || scannedBytes != u {
+		t.Errorf("scan bytes: %v, %v", scannedBytes, err)
+	}
+	if d := time.Since(u.Time()); d < 0 || d > time.Minute {
+		t.Errorf("time = %v", u.Time())
+	}
+	for _, s := range []string{"", "8ZZZZZZZZZZZZZZZZZZZZZZZZZ", "01H5ZZZZZZZZZZZZZZZZZZZZZU"} {
+		if _, err = ParseULID(s); err == nil {
+			t.Errorf("ParseULID(%q): expected error", s)
+		}
+	}
+}
+
+func TestULIDMonotonic(t *testing.T) {
+	clock := &manualClock{now: time.UnixMilli(1690790400000)}
+	generator := NewULIDGenerator()
+	generator.SetClock(clock)
+	var last ULID
+	for i := 0; i < 3000; i++ {
+		// 同一毫秒、下一毫秒及时钟回拨
+		switch i {
+		case 1000:
+			clock.now = clock.now.Add(time.Millisecond)
+		case 2000:
+			clock.now = clock.now.Add(-time.Second)
+		}
+		u, err := generator.CreateULID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.String() <= last.String() {
+			t.Fatalf("%d: %s <= %s", i, u, last)
+		}
+		last = u
+	}
+	if got := last.Time().UnixMilli(); got != 1690790400001 {
+		t.Errorf("time after clock backward = %d", got)
+	}
+}
+
+func toLower(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if c >= 'A' && c <= 'Z' {
+			b[i] = c | 0x20
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,243 @@
+/**
+ * @Time    :2023/7/31 14:05
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package id
+
+import (
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// UUID 128位 UUID，UUIDv7Generator 生成 UUIDv7（RFC 9562）：48位 Unix 毫秒时间戳 | 版本 | 12位计数器 | 变体 | 62位随机数
+type UUID [16]byte
+
+// ParseUUID
+/**
+ *  @Description: 解析 UUID 文本，支持 xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx 及32位十六进制
+ *  @param s
+ *  @return u
+ *  @return err
+ */
+func ParseUUID(s string) (u UUID, err error) {
+	src := []byte(s)
+	if len(src) == 36 {
+		if src[8] != '-' || src[13] != '-' || src[18] != '-' || src[23] != '-' {
+			err = errors.New("无效的UUID：" + strconv.Quote(s))
+			return
+		}
+		src = append(append(append(append(src[0:8:8], src[9:13]...), src[14:18]...), src[19:23]...), src[24:]...)
+	}
+	if len(src) != 32 {
+		err = errors.New("无效的UUID：" + strconv.Quote(s))
+		return
+	}
+	_, err = hex.Decode(u[:], src)
+	if err != nil {
+		err = errors.New("无效的UUID：" + strconv.Quote(s))
+	}
+	return
+}
+
+// String
+/**
+ *  @Description: 转换为 xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx 格式
+ *  @receiver u
+ *  @return string
+ */
+func (u UUID) String() string {
+	var b [36]byte
+	hex.Encode(b[0:8], u[0:4])
+	b[8] = '-'
+	hex.Encode(b[9:13], u[4:6])
+	b[13] = '-'
+	hex.Encode(b[14:18], u[6:8])
+	b[18] = '-'
+	hex.Encode(b[19:23], u[8:10])
+	b[23] = '-'
+	hex.Encode(b[24:], u[10:])
+	return string(b[:])
+}
+
+// Version
+/**
+ *  @Description: UUID 版本
+ *  @receiver u
+ *  @return int
+ */
+func (u UUID) Version() int {
+	return int(u[6] >> 4)
+}
+
+// Time
+/**
+ *  @Description: 获取 UUIDv7 的生成时间（毫秒精度），其他版本返回零值
+ *  @receiver u
+ *  @return time.Time
+ */
+func (u UUID) Time() time.Time {
+	if u.Version() != 7 {
+		return time.Time{}
+	}
+	return time.UnixMilli(int64(binary.BigEndian.Uint64(u[:8]) >> 16))
+}
+
+// IsZero
+/**
+ *  @Description: 是否为零值
+ *  @receiver u
+ *  @return bool
+ */
+func (u UUID) IsZero() bool {
+	return u == UUID{}
+}
+
+// MarshalText 实现 encoding.TextMarshaler
+func (u UUID) MarshalText() ([]byte, error) {
+	return []byte(u.String()), nil
+}
+
+// UnmarshalText 实现 encoding.TextUnmarshaler，空字符串解析为零值
+func (u *UUID) UnmarshalText(b []byte) (err error) {
+	if len(b) == 0 {
+		*u = UUID{}
+		return
+	}
+	*u, err = ParseUUID(string(b))
+	return
+}
+
+// MarshalJSON 输出为字符串
+func (u UUID) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + u.String() + `"`), nil
+}
+
+// UnmarshalJSON 解析字符串，null 时保持不变
+func (u *UUID) UnmarshalJSON(b []byte) error {
+	str, ok := unquoteJSON(b)
+	if !ok {
+		return errors.New("无效的UUID：" + string(b))
+	}
+	if str == nil {
+		return nil
+	}
+	return u.UnmarshalText(str)
+}
+
+// GormDataType 字段类型
+func (UUID) GormDataType() string {
+	return "char(36)"
+}
+
+// Value 写入数据库之前，对数据做类型转换
+func (u UUID) Value() (driver.Value, error) {
+	return u.String(), nil
+}
+
+// Scan 将数据库中取出的数据，赋值给目标类型，支持文本及16字节二进制，NULL 为零值
+func (u *UUID) Scan(v interface{}) error {
+	switch val := v.(type) {
+	case nil:
+		*u = UUID{}
+	case string:
+		return u.UnmarshalText([]byte(val))
+	case []byte:
+		if len(val) == len(u) {
+			copy(u[:], val)
+			return nil
+		}
+		return u.UnmarshalText(val)
+	default:
+		return errors.New(fmt.Sprintf("%T", v) + "类型处理错误")
+	}
+	return nil
+}
+
+// UUIDv7Generator UUIDv7生成器，同一毫秒内使用12位计数器保持递增
+type UUIDv7Generator struct {
+	mu      sync.Mutex
+	clock   Clock
+	time    int64
+	counter uint16
+}
+
+// NewUUIDv7Generator
+/**
+ *  @Description: 初始化UUIDv7生成器
+ *  @return *UUIDv7Generator
+ */
+func NewUUIDv7Generator() *UUIDv7Generator {
+	return &UUIDv7Generator{clock: systemClock{}}
+}
+
+// SetClock
+/**
+ *  @Description: 设置时钟，用于测试时注入可控时钟
+ *  @receiver generator
+ *  @param clock
+ */
+func (generator *UUIDv7Generator) SetClock(clock Clock) {
+	generator.mu.Lock()
+	defer generator.mu.Unlock()
+	if clock == nil {
+		clock = systemClock{}
+	}
+	generator.clock = clock
+}
+
+// CreateUUID
+/**
+ *  @Description: 创建一个UUIDv7，计数器溢出或时钟回拨时沿用上次的时间戳加1以保持递增
+ *  @receiver generator
+ *  @return u
+ *  @return err
+ */
+func (generator *UUIDv7Generator) CreateUUID() (u UUID, err error) {
+	err = randomBytes(u[6:])
+	if err != nil {
+		return
+	}
+	generator.mu.Lock()
+	defer generator.mu.Unlock()
+	now := unixMilli(generator.clock)
+	if now <= generator.time {
+		now = generator.time
+		generator.counter++
+		if generator.counter > 0xFFF {
+			now++
+			generator.counter = 0
+		}
+	} else {
+		// 计数器以随机数初始化，最高位置0以预留递增空间
+		generator.counter = binary.BigEndian.Uint16(u[6:8]) & 0x7FF
+	}
+	generator.time = now
+	binary.BigEndian.PutUint16(u[:2], uint16(now>>32))
+	binary.BigEndian.PutUint32(u[2:6], uint32(now))
+	binary.BigEndian.PutUint16(u[6:8], 0x7000|generator.counter)
+	// 变体 10
+	u[8] = u[8]&0x3F | 0x80
+	return
+}
+
+// NewID
+/**
+ *  @Description: 实现 Generator，同 CreateUUID
+ *  @receiver generator
+ *  @return ID
+ *  @return error
+ */
+func (generator *UUIDv7Generator) NewID() (ID, error) {
+	u, err := generator.CreateUUID()
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
@@ -0,0 +1,81 @@
+/**
+ * @Time    :2023/7/31 16:20
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package id
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestUUIDv7RoundTrip(t *testing.T) {
+	u, err := NewUUIDv7Generator().CreateUUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Version() != 7 || u[8]&0xC0 != 0x80 {
+		t.Errorf("version %d, variant %x", u.Version(), u[8]>>6)
+	}
+	parsed, err := ParseUUID(u.String())
+	if err != nil || parsed != u {
+		t.Fatalf("ParseUUID(%s) = %v, %v", u, parsed, err)
+	}
+	b, err := json.Marshal(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded UUID
+	if err = json.Unmarshal(b, &decoded); err != nil || decoded != u {
+		t.Errorf("json: %v, %v", decoded, err)
+	}
+	value, err := u.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var scanned, scannedBytes UUID
+	if err = scanned.Scan(value); err != nil || scanned != u {
+		t.Errorf("scan text: %v, %v", scanned, err)
+	}
+	if err = scannedBytes.Scan(u[:]); err != nil || scannedBytes != u {
+		t.Errorf("scan bytes: %v, %v", scannedBytes, err)
+	}
+	if d := time.Since(u.Time()); d < 0 || d > time.Minute {
+		t.Errorf("time = %v", u.Time())
+	}
+	for _, s := range []string{"", "01890a5d-ac96-774b-bcce-b302099a805", "01890a5d-ac96-774b-bcce-b302099a805g"} {
+		if _, err = ParseUUID(s); err == nil {
+			t.Errorf("ParseUUID(%q): expected error", s)
+		}
+	}
+}
+
+func TestUUIDv7Monotonic(t *testing.T) {
+	clock := &manualClock{now: time.UnixMilli(1690790400000)}
+	generator := NewUUIDv7Generator()
+	generator.SetClock(clock)
+	var last UUID
+	// 同一毫秒内超过计数器容量（4096）、时钟回拨
+	for i := 0; i < 10000; i++ {
+		if i == 9000 {
+			clock.now = clock.now.Add(-time.Second)
+		}
+		u, err := generator.CreateUUID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Compare(u[:], last[:]) <= 0 || u.String() <= last.String() {
+			t.Fatalf("%d: %s <= %s", i, u, last)
+		}
+		if u.Version() != 7 {
+			t.Fatalf("%d: version %d", i, u.Version())
+		}
+		last = u
+	}
+	if got := last.Time().UnixMilli(); got < 1690790400001 {
+		t.Errorf("time after counter overflow = %d", got)
+	}
+}
@@ -0,0 +1,385 @@
+/**
+ * @Time    :2023/7/19 09:30
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package plugin
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+)
+
+// sealGCM
+/**
+ *  @Description: 使用 AES-GCM 加密，密文格式为 nonce|密文
+ *  @param key 密钥，长度为 16、24、32
+ *  @param plaintext 明文
+ *  @param aad 附加认证数据
+ *  @return sealed
+ *  @return err
+ */
+func sealGCM(key, plaintext, aad []byte) (sealed []byte, err error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return
+	}
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(plaintext)+gcm.Overhead())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return
+	}
+	sealed = gcm.Seal(nonce, nonce, plaintext, aad)
+	return
+}
+
+// openGCM
+/**
+ *  @Description: 使用 AES-GCM 解密 sealGCM 生成的密文
+ *  @param key 密钥
+ *  @param sealed nonce|密文
+ *  @param aad 附加认证数据
+ *  @return plaintext
+ *  @return err
+ */
+func openGCM(key, sealed, aad []byte) (plaintext []byte, err error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return
+	}
+	if len(sealed) < gcm.NonceSize()+gcm.Overhead() {
+		err = errors.New("密文长度异常")
+		return
+	}
+	nonce := sealed[:gcm.NonceSize()]
+	return gcm.Open(nil, nonce, sealed[gcm.NonceSize():], aad)
+}
+
+// sealSIV
+/**
+ *  @Description: 确定性认证加密（SIV 构造），nonce 由 HMAC-SHA256(附加认证数据|明文) 生成，相同输入的密文相同
+ *  @param key 密钥，长度为 16、24、32
+ *  @param plaintext 明文
+ *  @param aad 附加认证数据
+ *  @return sealed nonce|密文
+ *  @return err
+ */
+func sealSIV(key, plaintext, aad []byte) (sealed []byte, err error) {
+	encKey, macKey := sivKeys(key)
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return
+	}
+	nonce := syntheticIV(macKey, plaintext, aad)[:gcm.NonceSize()]
+	sealed = gcm.Seal(nonce, nonce, plaintext, aad)
+	return
+}
+
+// openSIV
+/**
+ *  @Description: 解密 sealSIV 生成的密文，并校验合成的 nonce
+ *  @param key 密钥
+ *  @param sealed nonce|密文
+ *  @param aad 附加认证数据
+ *  @return plaintext
+ *  @return err
+ */
+func openSIV(key, sealed, aad []byte) (plaintext []byte, err error) {
+	encKey, macKey := sivKeys(key)
+	plaintext, err = openGCM(encKey, sealed, aad)
+	if err != nil {
+		return
+	}
+	// AES-GCM 标准 nonce 长度为 12
+	nonce := sealed[:12]
+	if !hmac.Equal(nonce, syntheticIV(macKey, plaintext, aad)[:len(nonce)]) {
+		plaintext, err = nil, errors.New("合成 nonce 校验失败")
+	}
+	return
+}
+
+// sivKeys
+/**
+ *  @Description: 从密钥派生 SIV 构造使用的加密密钥及 MAC 密钥
+ *  @param key
+ *  @return encKey
+ *  @return macKey
+ */
+func sivKeys(key []byte) (encKey, macKey []byte) {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("mt-siv-enc"))
+	encKey = mac.Sum(nil)[:len(key)]
+	mac = hmac.New(sha256.New, key)
+	mac.Write([]byte("mt-siv-mac"))
+	macKey = mac.Sum(nil)
+	return
+}
+
+// syntheticIV
+/**
+ *  @Description: 生成合成 IV：HMAC-SHA256(附加认证数据长度|附加认证数据|明文)
+ *  @param macKey
+ *  @param plaintext
+ *  @param aad
+ *  @return []byte
+ */
+func syntheticIV(macKey, plaintext, aad []byte) []byte {
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(aad)))
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(size[:])
+	mac.Write(aad)
+	mac.Write(plaintext)
+	return mac.Sum(nil)
+}
+
+// AES-GCM 密文的最小长度：nonce（12字节）+ 认证标签（16字节）
+const gcmSealedOverhead = 12 + 16
+
+const (
+	// AEADCipher 密文格式版本
+	aeadFormatV1 byte = 0xA1
+	// 密文绑定了所在行的主键
+	flagBindPrimaryKey byte = 1
+	// 确定性加密的密文
+	flagDeterministic byte = 2
+)
+
+// ErrTampered 密文校验失败
+var ErrTampered = errors.New("【gorm:multi-tenancy】密文校验失败，数据可能被篡改或复制自其他租户、表、字段、行")
+
+// TamperError 密文校验失败的错误，可通过 errors.Is(err, ErrTampered) 或 errors.As 判断
+type TamperError struct {
+	Field FieldInfo
+	Err   error
+}
+
+func (e *TamperError) Error() string {
+	msg := ErrTampered.Error() + "：" + e.Field.Table + "." + e.Field.Column
+	if e.Field.PrimaryKey != "" {
+		msg += "（主键：" + e.Field.PrimaryKey + "）"
+	}
+	return msg
+}
+
+func (e *TamperError) Is(target error) bool {
+	return target == ErrTampered
+}
+
+func (e *TamperError) Unwrap() error {
+	return e.Err
+}
+
+// AEADCipher 认证加密（AES-GCM），附加认证数据包含租户ID、表名、字段名，绑定主键的字段还包含所在行的主键，
+// 密文被篡改或复制到其他租户、表、字段、行时解密失败并返回 *TamperError；
+// 声明 mode:deterministic 的字段使用 SIV 构造的确定性加密，其余字段为随机加密
+type AEADCipher struct {
+	key []byte
+}
+
+// NewAEADCipher
+/**
+ *  @Description: 创建认证加密
+ *  @param key 密钥，长度为 16、24、32
+ *  @return c
+ *  @return err
+ */
+func NewAEADCipher(key []byte) (c *AEADCipher, err error) {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		err = errors.New("【gorm:multi-tenancy】密钥长度须为 16、24、32")
+		return
+	}
+	c = &AEADCipher{key: append([]byte(nil), key...)}
+	return
+}
+
+// Encrypt
+/**
+ *  @Description: 加密，字段声明绑定主键时绑定所在行的主键
+ *  @receiver c
+ *  @param ctx
+ *  @param field
+ *  @param plaintext
+ *  @return cipherTxt
+ *  @return err
+ */
+func (c *AEADCipher) Encrypt(ctx context.Context, field FieldInfo, plaintext string) (cipherTxt string, err error) {
+	if err = checkBinding(field); err != nil {
+		return
+	}
+	header := []byte{aeadFormatV1, bindFlag(field)}
+	sealed, err := seal(header[1], c.key, []byte(plaintext), fieldAAD(header, field))
+	if err != nil {
+		return
+	}
+	cipherTxt = base64.StdEncoding.EncodeToString(append(header, sealed...))
+	return
+}
+
+// Decrypt
+/**
+ *  @Description: 解密并校验密文所属的租户、表、字段及行
+ *  @receiver c
+ *  @param ctx
+ *  @param field
+ *  @param cipherTxt
+ *  @return plaintext
+ *  @return err
+ */
+func (c *AEADCipher) Decrypt(ctx context.Context, field FieldInfo, cipherTxt string) (plaintext string, err error) {
+	buf, err := base64.StdEncoding.DecodeString(cipherTxt)
+	if err != nil {
+		return
+	}
+	if len(buf) <= 2 || buf[0] != aeadFormatV1 {
+		err = errors.New("密文格式异常")
+		return
+	}
+	header := buf[:2]
+	err = checkFlag(header[1], field)
+	if err != nil {
+		return
+	}
+	data, err := open(header[1], c.key, buf[2:], fieldAAD(header, field))
+	if err != nil {
+		err = &TamperError{Field: field, Err: err}
+		return
+	}
+	plaintext = string(data)
+	return
+}
+
+// IsCipherTxt
+/**
+ *  @Description: 实现 CipherTxtChecker，校验 Base64 编码、格式版本及长度
+ *  @receiver c
+ *  @param value
+ *  @return bool
+ */
+func (c *AEADCipher) IsCipherTxt(value string) bool {
+	buf, err := base64.StdEncoding.DecodeString(value)
+	return err == nil && len(buf) >= 2+gcmSealedOverhead && buf[0] == aeadFormatV1
+}
+
+// bindFlag
+/**
+ *  @Description: 获取密文标识：是否绑定主键、是否为确定性加密
+ *  @param field
+ *  @return byte
+ */
+func bindFlag(field FieldInfo) (flag byte) {
+	if field.BindPrimaryKey {
+		flag |= flagBindPrimaryKey
+	}
+	if field.Mode == ModeDeterministic {
+		flag |= flagDeterministic
+	}
+	return
+}
+
+// seal
+/**
+ *  @Description: 按密文标识选择确定性或随机加密
+ *  @param flag
+ *  @param key
+ *  @param plaintext
+ *  @param aad
+ *  @return []byte
+ *  @return error
+ */
+func seal(flag byte, key, plaintext, aad []byte) ([]byte, error) {
+	if flag&flagDeterministic != 0 {
+		return sealSIV(key, plaintext, aad)
+	}
+	return sealGCM(key, plaintext, aad)
+}
+
+// open
+/**
+ *  @Description: 按密文标识选择确定性或随机解密
+ *  @param flag
+ *  @param key
+ *  @param sealed
+ *  @param aad
+ *  @return []byte
+ *  @return error
+ */
+func open(flag byte, key, sealed, aad []byte) ([]byte, error) {
+	if flag&flagDeterministic != 0 {
+		return openSIV(key, sealed, aad)
+	}
+	return openGCM(key, sealed, aad)
+}
+
+// checkBinding
+/**
+ *  @Description: 绑定主键的字段须指定所在行的主键
+ *  @param field
+ *  @return err
+ */
+func checkBinding(field FieldInfo) (err error) {
+	if field.BindPrimaryKey && field.PrimaryKey == "" {
+		err = errors.New(field.Table + "." + field.Column + "字段绑定了所在行的主键，读写时须指定主键")
+	}
+	return
+}
+
+// checkFlag
+/**
+ *  @Description: 校验密文的绑定标识与字段声明一致，是否绑定主键以字段声明为准，拒绝替换为未绑定主键的密文
+ *  @param flag 密文中的标识
+ *  @param field
+ *  @return err
+ */
+func checkFlag(flag byte, field FieldInfo) (err error) {
+	if err = checkBinding(field); err != nil {
+		return
+	}
+	if flag&flagBindPrimaryKey != bindFlag(field)&flagBindPrimaryKey {
+		err = &TamperError{Field: field, Err: errors.New("密文的主键绑定标识与字段声明不一致")}
+	}
+	return
+}
+
+// fieldAAD
+/**
+ *  @Description: 生成附加认证数据：密文头|租户ID|表名|字段名|主键（未绑定时为空），各部分带长度前缀
+ *  @param header 密文头
+ *  @param field
+ *  @return aad
+ */
+func fieldAAD(header []byte, field FieldInfo) (aad []byte) {
+	aad = append(aad, header...)
+	var primaryKey string
+	if field.BindPrimaryKey {
+		primaryKey = field.PrimaryKey
+	}
+	for _, part := range []string{field.TenantID, field.Table, field.Column, primaryKey} {
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(part)))
+		aad = append(aad, size[:]...)
+		aad = append(aad, part...)
+	}
+	return
+}
@@ -0,0 +1,263 @@
+/**
+ * @Time    :2023/7/19 10:15
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package plugin
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func TestAEADCipherBinding(t *testing.T) {
+	aead, err := NewAEADCipher([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	field := FieldInfo{Table: "users", Column: "phone", TenantID: "t1"}
+	cipherTxt, err := aead.Encrypt(ctx, field, "138")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name          string
+		field         FieldInfo
+		wantTamper    bool
+		wantPlaintext string
+	}{
+		{name: "same field", field: field, wantPlaintext: "138"},
+		{name: "other tenant", field: FieldInfo{Table: "users", Column: "phone", TenantID: "t2"}, wantTamper: true},
+		{name: "other table", field: FieldInfo{Table: "orders", Column: "phone", TenantID: "t1"}, wantTamper: true},
+		{name: "other column", field: FieldInfo{Table: "users", Column: "email", TenantID: "t1"}, wantTamper: true},
+		{name: "unbound cipher text for bound field", field: FieldInfo{Table: "users", Column: "phone", TenantID: "t1", PrimaryKey: "1", BindPrimaryKey: true}, wantTamper: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plaintext, err := aead.Decrypt(ctx, tt.field, cipherTxt)
+			if errors.Is(err, ErrTampered) != tt.wantTamper {
+				t.Fatalf("err = %v, wantTamper %v", err, tt.wantTamper)
+			}
+			if plaintext != tt.wantPlaintext {
+				t.Errorf("got %q, want %q", plaintext, tt.wantPlaintext)
+			}
+		})
+	}
+}
+
+func TestAEADCipherBindPrimaryKey(t *testing.T) {
+	aead, err := NewAEADCipher([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	field := FieldInfo{Table: "users", Column: "phone", PrimaryKey: "1", BindPrimaryKey: true}
+	if _, err = aead.Encrypt(ctx, FieldInfo{Table: "users", Column: "phone", BindPrimaryKey: true}, "138"); err == nil {
+		t.Fatal("encrypt bound field without primary key should fail")
+	}
+	cipherTxt, err := aead.Encrypt(ctx, field, "138")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name          string
+		field         FieldInfo
+		wantErr       bool
+		wantTamper    bool
+		wantPlaintext string
+	}{
+		{name: "same row", field: field, wantPlaintext: "138"},
+		{name: "other row", field: FieldInfo{Table: "users", Column: "phone", PrimaryKey: "2", BindPrimaryKey: true}, wantErr: true, wantTamper: true},
+		{name: "without primary key", field: FieldInfo{Table: "users", Column: "phone", BindPrimaryKey: true}, wantErr: true},
+		{name: "field not bound", field: FieldInfo{Table: "users", Column: "phone", PrimaryKey: "1"}, wantErr: true, wantTamper: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plaintext, err := aead.Decrypt(ctx, tt.field, cipherTxt)
+			if (err != nil) != tt.wantErr || errors.Is(err, ErrTampered) != tt.wantTamper {
+				t.Fatalf("err = %v, wantErr %v, wantTamper %v", err, tt.wantErr, tt.wantTamper)
+			}
+			if plaintext != tt.wantPlaintext {
+				t.Errorf("got %q, want %q", plaintext, tt.wantPlaintext)
+			}
+		})
+	}
+}
+
+type boundUser struct {
+	ID      int64 `gorm:"primaryKey;autoIncrement:false"`
+	Name    string
+	Phone   string `mt:"encrypt;bind"`
+	Balance int64  `mt:"encrypt;bind" gorm:"type:varchar(255);serializer:mt_encrypt"`
+}
+
+// boundCipherTxt
+/**
+ *  @Description: 获取语句参数中的密文并按主键解密
+ *  @param t
+ *  @param aead
+ *  @param vars 语句参数
+ *  @param column
+ *  @param primaryKey
+ *  @return plaintext
+ */
+func boundCipherTxt(t *testing.T, aead *AEADCipher, vars []interface{}, column string, primaryKey string) (plaintext string) {
+	t.Helper()
+	for _, v := range vars {
+		if valuer, ok := v.(driver.Valuer); ok {
+			var err error
+			if v, err = valuer.Value(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		cipherTxt, ok := v.(string)
+		if !ok || !aead.IsCipherTxt(cipherTxt) {
+			continue
+		}
+		field := FieldInfo{Table: "bound_users", Column: column, PrimaryKey: primaryKey, BindPrimaryKey: true}
+		plaintext, err := aead.Decrypt(context.Background(), field, cipherTxt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return plaintext
+	}
+	t.Fatalf("no cipher text in %#v", vars)
+	return
+}
+
+func TestBindPrimaryKeyWrite(t *testing.T) {
+	aead, err := NewAEADCipher([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, db := newTestDB(t, aead)
+	tests := []struct {
+		name    string
+		exec    func(tx *gorm.DB) *gorm.DB
+		column  string
+		want    string
+		wantErr bool
+	}{
+		{name: "create", column: "phone", want: "138", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Create(&boundUser{ID: 7, Phone: "138"})
+		}},
+		{name: "create serializer", column: "balance", want: "10", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Create(&boundUser{ID: 7, Balance: 10})
+		}},
+		{name: "create map", column: "phone", want: "138", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&boundUser{}).Create(map[string]interface{}{"ID": 7, "Phone": "138"})
+		}},
+		{name: "create without primary key", wantErr: true, exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Create(&boundUser{Phone: "138"})
+		}},
+		{name: "updates map", column: "phone", want: "138", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&boundUser{ID: 7}).Updates(map[string]interface{}{"phone": "138"})
+		}},
+		{name: "update column by where", column: "phone", want: "138", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&boundUser{}).Where("id = ?", 7).Update("phone", "138")
+		}},
+		{name: "updates struct", column: "phone", want: "138", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&boundUser{ID: 7}).Updates(boundUser{Phone: "138"})
+		}},
+		{name: "updates struct serializer", column: "balance", want: "10", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&boundUser{ID: 7}).Updates(boundUser{Balance: 10})
+		}},
+		{name: "set clause", column: "phone", want: "138", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&boundUser{}).Where("id = ?", 7).Clauses(clause.Set{{Column: clause.Column{Name: "phone"}, Value: "138"}}).Updates(map[string]interface{}{})
+		}},
+		{name: "update multiple rows", wantErr: true, exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&boundUser{}).Where("id IN ?", []int64{7, 8}).Update("phone", "138")
+		}},
+		{name: "update without primary key", wantErr: true, exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&boundUser{}).Where("name = ?", "a").Update("phone", "138")
+		}},
+		{name: "on conflict", wantErr: true, exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Clauses(clause.OnConflict{DoUpdates: clause.Assignments(map[string]interface{}{"phone": "138"})}).Create(&boundUser{ID: 7, Phone: "138"})
+		}},
+		{name: "condition", wantErr: true, exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Where("phone = ?", "138").Find(&[]boundUser{})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := tt.exec(db.Session(&gorm.Session{NewDB: true, Context: context.Background()}))
+			if (tx.Error != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", tx.Error, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := boundCipherTxt(t, aead, tx.Statement.Vars, tt.column, "7"); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBindPrimaryKeySelect(t *testing.T) {
+	aead, err := NewAEADCipher([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mt, db := newTestDB(t, aead)
+	tests := []struct {
+		name    string
+		exec    func(tx *gorm.DB) *gorm.DB
+		wantSQL string
+		wantErr bool
+	}{
+		{name: "select without primary key", wantSQL: "SELECT `id`,`phone` FROM `bound_users`", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Select("phone").Find(&[]boundUser{})
+		}},
+		{name: "map dest", wantSQL: "SELECT `id`,`balance` FROM `bound_users`", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&boundUser{}).Select("balance").Find(&[]map[string]interface{}{})
+		}},
+		{name: "select with primary key", wantSQL: "SELECT `phone`,`id` FROM `bound_users`", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Select("phone", "id").Find(&[]boundUser{})
+		}},
+		{name: "select unbound column", wantSQL: "SELECT `name` FROM `bound_users`", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Select("name").Find(&[]boundUser{})
+		}},
+		{name: "pluck", wantErr: true, exec: func(tx *gorm.DB) *gorm.DB {
+			var phones []string
+			return tx.Model(&boundUser{}).Pluck("phone", &phones)
+		}},
+		{name: "distinct", wantErr: true, exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Distinct("phone").Find(&[]boundUser{})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := tt.exec(db.Session(&gorm.Session{NewDB: true, Context: context.Background()}))
+			if (tx.Error != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", tx.Error, tt.wantErr)
+			}
+			if !tt.wantErr && tx.Statement.SQL.String() != tt.wantSQL {
+				t.Errorf("got %q, want %q", tx.Statement.SQL.String(), tt.wantSQL)
+			}
+		})
+	}
+
+	// 解密后清除自动查询的主键
+	tx := db.Session(&gorm.Session{NewDB: true, Context: context.Background()}).Model(&boundUser{})
+	if err = tx.Statement.Parse(&boundUser{}); err != nil {
+		t.Fatal(err)
+	}
+	users := []boundUser{{ID: 7, Phone: "138"}}
+	rows := []map[string]interface{}{{"id": int64(7), "phone": "138"}}
+	mt.clearPrimaryKey(tx, reflect.ValueOf(&users))
+	mt.clearPrimaryKey(tx, reflect.ValueOf(&rows))
+	if users[0].ID != 0 || users[0].Phone != "138" {
+		t.Errorf("struct primary key not cleared: %#v", users[0])
+	}
+	if _, ok := rows[0]["id"]; ok || rows[0]["phone"] != "138" {
+		t.Errorf("map primary key not cleared: %#v", rows[0])
+	}
+}
@@ -8,10 +8,14 @@ package plugin
 import (
 	"errors"
 	"fmt"
+	"github.com/melf-xyzh/multi-tenancy/id"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
 	"gorm.io/gorm/utils"
 	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -22,18 +26,31 @@ type Model interface {
 }
 
 func (mt *MultiTenancy) registerCallbacks(db *gorm.DB) {
-	// 分库存储
+	// 加密存储
+	mt.Callback().Create().Before("*").Register("gorm:multi-tenancy-encrypt", mt.encryptCreateBeforeCallback)
+	mt.Callback().Query().Before("*").Register("gorm:multi-tenancy-encrypt", mt.encryptQueryBeforeCallback)
+	mt.Callback().Update().Before("*").Register("gorm:multi-tenancy-encrypt", mt.encryptUpdateBeforeCallback)
+	mt.Callback().Delete().Before("*").Register("gorm:multi-tenancy-encrypt", mt.encryptDeleteBeforeCallback)
+	mt.Callback().Row().Before("*").Register("gorm:multi-tenancy-encrypt", mt.encryptQueryBeforeCallback)
+	mt.Callback().Query().After("*").Register("gorm:multi-tenancy-decrypt", mt.decryptQueryAfterCallback)
+	mt.Callback().Create().After("*").Register("gorm:multi-tenancy-restore", mt.restoreAfterCallback)
+	mt.Callback().Query().After("*").Register("gorm:multi-tenancy-restore", mt.restoreAfterCallback)
+	mt.Callback().Update().After("*").Register("gorm:multi-tenancy-restore", mt.restoreAfterCallback)
+	mt.Callback().Delete().After("*").Register("gorm:multi-tenancy-restore", mt.restoreAfterCallback)
+	mt.Callback().Row().After("*").Register("gorm:multi-tenancy-restore", mt.restoreAfterCallback)
+	// 字符串时间字段
+	mt.Callback().Create().Before("*").Register("gorm:multi-tenancy-time", mt.timeCreateBeforeCallback)
+	mt.Callback().Update().Before("*").Register("gorm:multi-tenancy-time", mt.timeUpdateBeforeCallback)
+	mt.Callback().Delete().Before("*").Register("gorm:multi-tenancy-time", mt.timeDeleteBeforeCallback)
+	// 分布式ID（须在加密前生成，密文绑定所在行的主键）
+	mt.Callback().Create().Before("*").Register("gorm:multi-tenancy-id", mt.idCreateBeforeCallback)
+	// 分库存储（Before("*") 的回调后注册先执行，须在加密前确定租户，以选择租户的数据密钥及ID生成器）
 	mt.Callback().Create().Before("*").Register("gorm:multi-tenancy", mt.createBeforeCallback)
 	mt.Callback().Query().Before("*").Register("gorm:multi-tenancy", mt.queryBeforeCallback)
 	mt.Callback().Update().Before("*").Register("gorm:multi-tenancy", mt.updateBeforeCallback)
 	mt.Callback().Delete().Before("*").Register("gorm:multi-tenancy", mt.deleteBeforeCallback)
 	mt.Callback().Row().Before("*").Register("gorm:multi-tenancy", mt.rowBeforeCallback)
 	mt.Callback().Raw().Before("*").Register("gorm:multi-tenancy", mt.rawBeforeCallback)
-	// 加密存储
-	mt.Callback().Create().Before("*").Register("gorm:multi-tenancy-encrypt", mt.encryptCreateBeforeCallback)
-	mt.Callback().Query().Before("*").Register("gorm:multi-tenancy-encrypt", mt.encryptQueryBeforeCallback)
-	mt.Callback().Update().Before("*").Register("gorm:multi-tenancy-encrypt", mt.encryptUpdateBeforeCallback)
-	mt.Callback().Query().After("*").Register("gorm:multi-tenancy-decrypt", mt.decryptQueryAfterCallback)
 }
 
 func (mt *MultiTenancy) createBeforeCallback(db *gorm.DB) {
@@ -62,6 +79,8 @@ func (mt *MultiTenancy) commonCallback(db *gorm.DB, getTenantId func(db *gorm.DB
 	if db.Error != nil {
 		return
 	}
+	// 记录租户ID，加解密时选择租户的数据密钥
+	db.Statement.Context = WithTenantId(db.Statement.Context, tenantId)
 	mt.AutoMigrate(db, tenantId, model)
 
 }
@@ -116,6 +135,13 @@ func (mt *MultiTenancy) SetDataIsolation(model ...Model) (err error) {
 		mt.dataIsolation = make(map[string]Model)
 	}
 	for _, m := range model {
+		// 注册时校验模型的 mt Tag
+		if mt.DB != nil {
+			_, err = mt.ModelTags(m)
+			if err != nil {
+				return
+			}
+		}
 		mt.dataIsolation[m.TableName()] = m
 	}
 	return
@@ -135,6 +161,9 @@ func (mt *MultiTenancy) DataIsolation(db *gorm.DB) (model Model, dataIsolation b
 		dataIsolation = model.DataIsolation()
 		return
 	}
+	if db.Statement.Schema == nil {
+		return
+	}
 	model, ok = mt.dataIsolation[db.Statement.Schema.Table]
 	if ok {
 		dataIsolation = model.DataIsolation()
@@ -194,30 +223,177 @@ func (mt *MultiTenancy) getTenantIdBySql(db *gorm.DB) (tenantId string) {
 	defer func() { tenantId = strings.TrimSpace(tenantId) }()
 	whereClauses, ok := db.Statement.Clauses["WHERE"].Expression.(clause.Where)
 	if !ok {
-		db.Error = mt.newError("未检测到 WHERE 子句")
+		// 按主键查询、更新、删除时，由主键中的租户编号获取租户
+		tenantId = mt.getTenantIdByPrimaryKey(db, nil)
+		if db.Error == nil && tenantId == "" {
+			db.Error = mt.newError("未检测到 WHERE 子句")
+		}
 		return
 	}
-	var build strings.Builder
-	for i, expr := range whereClauses.Exprs {
-		v := expr.(clause.Expr)
+	var sqls []string
+	for _, expr := range whereClauses.Exprs {
+		v, ok := expr.(clause.Expr)
+		if !ok {
+			// 主键、结构体等条件
+			continue
+		}
 		sql := v.SQL
 		for _, vi := range v.Vars {
 			sql = strings.Replace(sql, "?", utils.ToString(vi), 1)
 		}
-		build.WriteString(sql)
-		if i != len(whereClauses.Exprs)-1 {
-			build.WriteString(" AND ")
-		}
+		sqls = append(sqls, sql)
 	}
-	sql := build.String()
+	sql := strings.Join(sqls, " AND ")
+	tenantColumn := mt.tenantColumn(db)
 	strs := strings.Split(sql, "AND")
 	for _, stri := range strs {
 		sqlField := strings.Split(stri, "=")
-		if strings.TrimSpace(sqlField[0]) == mt.getTenantTag() {
+		if strings.TrimSpace(sqlField[0]) == tenantColumn {
 			tenantId = sqlField[1]
 			return
 		}
 	}
+	// 条件中不包含数据隔离字段时，由主键中的租户编号获取租户
+	return mt.getTenantIdByPrimaryKey(db, whereClauses.Exprs)
+}
+
+// 主键条件，如 id = ?、`users`.`id` IN ?
+var primaryKeyExprRegexp = regexp.MustCompile("(?i)^\\s*(?:[`\"]?\\w+[`\"]?\\.)?[`\"]?(\\w+)[`\"]?\\s*(?:=|IN)\\s*\\(?\\?\\)?\\s*$")
+
+// getTenantIdByPrimaryKey
+/**
+ *  @Description: 由声明 mt:"layout:名称" 的 DistributedId 主键中的租户编号（按声明的ID结构解析）获取租户，主键条件及 Model 的主键均须属于同一租户
+ *  @receiver mt
+ *  @param db
+ *  @param exprs WHERE 子句中的条件
+ *  @return tenantId 未找到主键或租户编号未注册时为空
+ */
+func (mt *MultiTenancy) getTenantIdByPrimaryKey(db *gorm.DB, exprs []clause.Expression) (tenantId string) {
+	sch := db.Statement.Schema
+	if sch == nil || sch.PrioritizedPrimaryField == nil || !isDistributedId(sch.PrioritizedPrimaryField.FieldType) {
+		return
+	}
+	// 仅主键声明 mt:"layout:名称" 的模型由ID中的租户编号选择数据库，其他ID结构的相同位不是租户编号
+	tags := mt.analyzeSchema(sch)
+	if !tags.tenantLayout {
+		return
+	}
+	for _, value := range mt.primaryKeyValues(db, exprs) {
+		distributedId, ok := toDistributedId(value)
+		if !ok {
+			return ""
+		}
+		tenantIdi, ok := tags.layout.TenantOf(distributedId)
+		if !ok {
+			return ""
+		}
+		if tenantId != "" && tenantIdi != tenantId {
+			db.Error = mt.newError("主键属于不同的租户，不支持跨库操作")
+			return ""
+		}
+		tenantId = tenantIdi
+	}
+	return
+}
+
+// primaryKeyValues
+/**
+ *  @Description: 获取语句中的主键值：WHERE 子句中的主键条件及 Model 的主键
+ *  @receiver mt
+ *  @param db
+ *  @param exprs WHERE 子句中的条件
+ *  @return values 已展开切片
+ */
+func (mt *MultiTenancy) primaryKeyValues(db *gorm.DB, exprs []clause.Expression) (values []interface{}) {
+	sch := db.Statement.Schema
+	if sch == nil || sch.PrioritizedPrimaryField == nil {
+		return
+	}
+	pkField := sch.PrioritizedPrimaryField
+	isPrimaryKey := func(column interface{}) bool {
+		name := mt.columnName(column)
+		return name == clause.PrimaryKey || name == pkField.DBName
+	}
+	for _, expr := range exprs {
+		switch v := expr.(type) {
+		case clause.IN:
+			if isPrimaryKey(v.Column) {
+				values = append(values, v.Values...)
+			}
+		case clause.Eq:
+			if isPrimaryKey(v.Column) {
+				values = append(values, v.Value)
+			}
+		case clause.Expr:
+			if matches := primaryKeyExprRegexp.FindStringSubmatch(v.SQL); len(matches) == 2 && matches[1] == pkField.DBName && len(v.Vars) == 1 {
+				values = append(values, v.Vars[0])
+			}
+		}
+	}
+	// 通过 Model 更新、删除时，主键条件在执行时生成（此时 ReflectValue 尚未切换为 Model）
+	rvs := []reflect.Value{db.Statement.ReflectValue}
+	if db.Statement.Model != nil && db.Statement.Model != db.Statement.Dest {
+		rvs = append(rvs, reflect.ValueOf(db.Statement.Model))
+	}
+	for _, rv := range rvs {
+		rv = reflect.Indirect(rv)
+		elems := []reflect.Value{rv}
+		if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+			elems = elems[:0]
+			for i := 0; i < rv.Len(); i++ {
+				elems = append(elems, reflect.Indirect(rv.Index(i)))
+			}
+		}
+		for _, elem := range elems {
+			if elem.Kind() != reflect.Struct || elem.Type() != sch.ModelType {
+				continue
+			}
+			if value, isZero := pkField.ValueOf(db.Statement.Context, elem); !isZero {
+				values = append(values, value)
+			}
+		}
+	}
+	return flattenValues(values)
+}
+
+// flattenValues
+/**
+ *  @Description: 展开条件值中的切片，如 id IN ? 的参数
+ *  @param values
+ *  @return flattened
+ */
+func flattenValues(values []interface{}) (flattened []interface{}) {
+	for _, value := range values {
+		rv := reflect.ValueOf(value)
+		if (rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() != reflect.Uint8) || rv.Kind() == reflect.Array {
+			for i := 0; i < rv.Len(); i++ {
+				flattened = append(flattened, rv.Index(i).Interface())
+			}
+			continue
+		}
+		flattened = append(flattened, value)
+	}
+	return
+}
+
+// toDistributedId
+/**
+ *  @Description: 将主键条件值转换为 DistributedId
+ *  @param value
+ *  @return distributedId
+ *  @return ok
+ */
+func toDistributedId(value interface{}) (distributedId id.DistributedId, ok bool) {
+	rv := reflect.Indirect(reflect.ValueOf(value))
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return id.DistributedId(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return id.DistributedId(rv.Uint()), true
+	case reflect.String:
+		num, err := strconv.ParseInt(rv.String(), 10, 64)
+		return id.DistributedId(num), err == nil
+	}
 	return
 }
 
@@ -232,9 +408,10 @@ func (mt *MultiTenancy) getTenantIdByModel(db *gorm.DB) (tenantId string) {
 	defer func() { tenantId = strings.TrimSpace(tenantId) }()
 	var ok bool
 	if db.Statement.Schema != nil {
+		tenantColumn := mt.tenantColumn(db)
 		for _, field := range db.Statement.Schema.Fields {
 			// 判断是否分库字段
-			if field.DBName != mt.getTenantTag() {
+			if field.DBName != tenantColumn {
 				continue
 			}
 			switch db.Statement.ReflectValue.Kind() {
@@ -274,3 +451,41 @@ func (mt *MultiTenancy) getTenantIdByModel(db *gorm.DB) (tenantId string) {
 	}
 	return
 }
+
+// tenantColumn
+/**
+ *  @Description: 获取语句模型的数据隔离字段，优先使用模型中声明 mt:"tenant" 的字段，未声明时使用全局的数据隔离字段标识
+ *  @receiver mt
+ *  @param db
+ *  @return string
+ */
+func (mt *MultiTenancy) tenantColumn(db *gorm.DB) string {
+	sch := db.Statement.Schema
+	if sch == nil {
+		// 通过 Table 指定表名时，使用注册的数据隔离模型
+		model, ok := mt.dataIsolation[db.Statement.Table]
+		if !ok {
+			return mt.getTenantTag()
+		}
+		tx := mt.DB.Session(&gorm.Session{NewDB: true, Context: db.Statement.Context})
+		if tx.Statement.Parse(model) != nil {
+			return mt.getTenantTag()
+		}
+		sch = tx.Statement.Schema
+	}
+	return mt.schemaTenantColumn(sch)
+}
+
+// schemaTenantColumn
+/**
+ *  @Description: 获取模型的数据隔离字段
+ *  @receiver mt
+ *  @param sch
+ *  @return string
+ */
+func (mt *MultiTenancy) schemaTenantColumn(sch *schema.Schema) string {
+	if tenantField := mt.analyzeSchema(sch).tenantField; tenantField != "" {
+		return tenantField
+	}
+	return mt.getTenantTag()
+}
@@ -0,0 +1,112 @@
+/**
+ * @Time    :2023/5/17 14:20
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/melf-xyzh/multi-tenancy/id"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type layoutOrder struct {
+	ID   id.DistributedId `mt:"layout:tenant"`
+	Name string
+}
+
+type plainOrder struct {
+	ID   id.DistributedId
+	Name string
+}
+
+type badLayoutOrder struct {
+	ID   int64 `mt:"layout:tenant"`
+	Name string
+}
+
+func TestTenantLayoutRouting(t *testing.T) {
+	mt, db := newTestDB(t, nil)
+	generator, err := id.InitTenantIdGenerator("layout-t1", 201, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = id.RegisterGenerator(generator); err != nil {
+		t.Fatal(err)
+	}
+	orderId := generator.CreateId()
+	for model, want := range map[interface{}]string{&layoutOrder{}: "layout-t1", &plainOrder{}: ""} {
+		tx, _ := testStatement(t, mt, db, model)
+		tx.Statement.AddClause(clause.Where{Exprs: []clause.Expression{clause.Eq{Column: clause.PrimaryColumn, Value: orderId}}})
+		if got := mt.getTenantIdByPrimaryKey(tx, tx.Statement.Clauses["WHERE"].Expression.(clause.Where).Exprs); got != want {
+			t.Errorf("%T: got %q, want %q", model, got, want)
+		}
+	}
+	if _, err = mt.ModelTags(&badLayoutOrder{}); err == nil {
+		t.Error("layout on non DistributedId primary key: expected error")
+	}
+	if _, err = ParseTag("layout:tenant;encrypt"); err == nil {
+		t.Error("layout with other options: expected error")
+	}
+
+	defaultGenerator, err := id.InitDistributedIdGenerator("", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mt.SetIdGenerator(defaultGenerator)
+	tx := db.Session(&gorm.Session{NewDB: true, Context: context.Background()}).Create(&layoutOrder{Name: "a"})
+	if tx.Error == nil {
+		t.Error("default layout generator for tenant layout model: expected error")
+	}
+}
+
+type wideOrder struct {
+	ID   id.DistributedId `mt:"layout:routing-wide"`
+	Name string
+}
+
+type unknownLayoutOrder struct {
+	ID   id.DistributedId `mt:"layout:routing-unknown"`
+	Name string
+}
+
+func TestCustomLayoutRouting(t *testing.T) {
+	mt, db := newTestDB(t, nil)
+	wide := id.Layout{Epoch: id.TenantLayout.Epoch, TenantBits: 12, NodeBits: 4, StepBits: 8}
+	if err := id.RegisterLayout("routing-wide", wide); err != nil {
+		t.Fatal(err)
+	}
+	generator, err := id.NewDistributedIdGenerator("wide-t1", 2049, 1, wide)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = id.RegisterGenerator(generator); err != nil {
+		t.Fatal(err)
+	}
+	orderId := generator.CreateId()
+	tx, _ := testStatement(t, mt, db, &wideOrder{})
+	tx.Statement.AddClause(clause.Where{Exprs: []clause.Expression{clause.Eq{Column: clause.PrimaryColumn, Value: orderId}}})
+	if got := mt.getTenantIdByPrimaryKey(tx, tx.Statement.Clauses["WHERE"].Expression.(clause.Where).Exprs); got != "wide-t1" {
+		t.Errorf("got %q, want %q", got, "wide-t1")
+	}
+	if _, err = mt.ModelTags(&unknownLayoutOrder{}); err == nil {
+		t.Error("unregistered layout: expected error")
+	}
+
+	mt.SetIdGenerator(generator)
+	if tx = db.Session(&gorm.Session{NewDB: true, Context: context.Background()}).Create(&wideOrder{Name: "a"}); tx.Error != nil {
+		t.Error(tx.Error)
+	}
+	tenantGenerator, err := id.InitTenantIdGenerator("wide-t2", 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mt.SetIdGenerator(tenantGenerator)
+	if tx = db.Session(&gorm.Session{NewDB: true, Context: context.Background()}).Create(&wideOrder{Name: "a"}); tx.Error == nil {
+		t.Error("tenant layout generator for wide layout model: expected error")
+	}
+}
@@ -0,0 +1,228 @@
+/**
+ * @Time    :2023/7/21 09:20
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+)
+
+// FieldInfo 加解密字段信息
+type FieldInfo struct {
+	Table      string // 模型的表名
+	Column     string // 字段名
+	TenantID   string // 租户ID，未进行数据隔离且未通过 WithTenantId 指定时为空
+	PrimaryKey string // 所在行的主键，仅绑定主键的字段不为空
+	Mode       string // 加密方式：deterministic、random，未指定时为空
+	// 密文是否绑定所在行的主键（mt:"encrypt;bind"），由字段声明决定，解密时须拒绝未绑定主键的密文
+	BindPrimaryKey bool
+}
+
+// FieldCipher 字段加解密接口，可按租户、表及字段选择密钥或算法
+type FieldCipher interface {
+	Encrypt(ctx context.Context, field FieldInfo, plaintext string) (cipherTxt string, err error)
+	Decrypt(ctx context.Context, field FieldInfo, cipherTxt string) (plaintext string, err error)
+}
+
+// BatchFieldCipher 支持批量加密的字段加解密接口，批量创建时同一字段的值将合并加密，fields 与 plaintexts 一一对应
+type BatchFieldCipher interface {
+	FieldCipher
+	EncryptBatch(ctx context.Context, fields []FieldInfo, plaintexts []string) (cipherTxts []string, err error)
+}
+
+// CipherTxtChecker 可校验密文格式的字段加解密接口，兼容读取及存量数据加密据此区分明文与密文
+type CipherTxtChecker interface {
+	// IsCipherTxt 值是否为该实现生成的密文格式，仅校验格式（如格式版本），不解密
+	IsCipherTxt(value string) bool
+}
+
+// LegacyCipher 可识别旧版本密文的字段加解密接口，旧版本密文读取时返回错误，仅由存量数据加密（EncryptExisting）解密后按当前格式重新加密
+type LegacyCipher interface {
+	// IsLegacyCipherTxt 值是否为旧版本的密文格式，仅校验格式，不解密
+	IsLegacyCipherTxt(value string) bool
+	// DecryptLegacy 解密旧版本的密文
+	DecryptLegacy(ctx context.Context, field FieldInfo, cipherTxt string) (plaintext string, err error)
+}
+
+// funcCipher 将加解密函数适配为 FieldCipher
+type funcCipher struct {
+	encrypt func(data string) (cipherTxt string, err error)
+	decrypt func(cipherTxt string) (data string, err error)
+}
+
+func (c funcCipher) Encrypt(ctx context.Context, field FieldInfo, plaintext string) (string, error) {
+	return c.encrypt(plaintext)
+}
+
+func (c funcCipher) Decrypt(ctx context.Context, field FieldInfo, cipherTxt string) (string, error) {
+	return c.decrypt(cipherTxt)
+}
+
+// SetFieldCipher
+/**
+ *  @Description: 注册字段加解密实现
+ *  @receiver mt
+ *  @param cipher
+ */
+func (mt *MultiTenancy) SetFieldCipher(cipher FieldCipher) {
+	mt.encryptedSave = true
+	mt.cipher = cipher
+	return
+}
+
+// SetCipherTxtFormat
+/**
+ *  @Description: 注册密文格式校验，用于未实现 CipherTxtChecker 的加解密实现（如 SetEncryptedSave 注册的加解密函数）
+ *  @receiver mt
+ *  @param isCipherTxt 值是否为密文格式，仅校验格式，不解密
+ */
+func (mt *MultiTenancy) SetCipherTxtFormat(isCipherTxt func(value string) bool) {
+	mt.cipherTxtFormat = isCipherTxt
+	return
+}
+
+// isCipherTxtFormat
+/**
+ *  @Description: 值是否为密文格式
+ *  @receiver mt
+ *  @param value
+ *  @return isCipherTxt
+ *  @return err 加解密实现未提供密文格式校验
+ */
+func (mt *MultiTenancy) isCipherTxtFormat(value string) (isCipherTxt bool, err error) {
+	if checker, ok := mt.cipher.(CipherTxtChecker); ok {
+		return checker.IsCipherTxt(value), nil
+	}
+	if mt.cipherTxtFormat != nil {
+		return mt.cipherTxtFormat(value), nil
+	}
+	err = mt.newError("加解密实现未提供密文格式校验，无法区分明文与密文，请实现 CipherTxtChecker 或通过 SetCipherTxtFormat 注册")
+	return
+}
+
+// isLegacyCipherTxt
+/**
+ *  @Description: 值是否为旧版本的密文格式
+ *  @receiver mt
+ *  @param value
+ *  @return bool
+ */
+func (mt *MultiTenancy) isLegacyCipherTxt(value string) bool {
+	legacy, ok := mt.cipher.(LegacyCipher)
+	return ok && legacy.IsLegacyCipherTxt(value)
+}
+
+// fieldInfo
+/**
+ *  @Description: 获取加解密字段信息
+ *  @param ctx
+ *  @param mtTag
+ *  @param primaryKey 所在行的主键，未知时为空
+ *  @return FieldInfo
+ */
+func fieldInfo(ctx context.Context, mtTag MultiTenancyTag, primaryKey string) FieldInfo {
+	if !mtTag.Bind {
+		// 未声明 bind 的字段不绑定所在行的主键
+		primaryKey = ""
+	}
+	return FieldInfo{
+		Table:          mtTag.Table,
+		Column:         mtTag.DBName,
+		TenantID:       TenantIdFromContext(ctx),
+		PrimaryKey:     primaryKey,
+		Mode:           mtTag.Mode,
+		BindPrimaryKey: mtTag.Bind,
+	}
+}
+
+// checkPrimaryKey
+/**
+ *  @Description: 绑定主键的字段须已知所在行的主键
+ *  @receiver mt
+ *  @param mtTag
+ *  @param primaryKey
+ *  @return err
+ */
+func (mt *MultiTenancy) checkPrimaryKey(mtTag MultiTenancyTag, primaryKey string) (err error) {
+	if mtTag.Bind && primaryKey == "" {
+		err = mt.newError(mtTag.Table + "." + mtTag.DBName + "字段绑定了所在行的主键，读写时须已知主键（创建时须已赋值主键，查询时须包含主键，更新时须指定单个主键）")
+	}
+	return
+}
+
+// isRandomized
+/**
+ *  @Description: 字段是否为随机加密，绑定主键的字段为随机加密，未指定加密方式时内置的认证加密及信封加密为随机加密
+ *  @receiver mt
+ *  @param mtTag
+ *  @return bool
+ */
+func (mt *MultiTenancy) isRandomized(mtTag MultiTenancyTag) bool {
+	if mtTag.Bind {
+		// 绑定主键的密文各行不同
+		return true
+	}
+	switch mtTag.Mode {
+	case ModeRandom:
+		return true
+	case ModeDeterministic:
+		return false
+	}
+	switch mt.cipher.(type) {
+	case *AEADCipher, *Envelope:
+		return true
+	}
+	return false
+}
+
+// encryptTexts
+/**
+ *  @Description: 加密同一字段的多个明文，实现 BatchFieldCipher 时合并加密
+ *  @receiver mt
+ *  @param ctx
+ *  @param mtTag
+ *  @param primaryKeys 各明文所在行的主键，与 plaintexts 一一对应
+ *  @param plaintexts
+ *  @return cipherTxts
+ *  @return err
+ */
+func (mt *MultiTenancy) encryptTexts(ctx context.Context, mtTag MultiTenancyTag, primaryKeys []string, plaintexts []string) (cipherTxts []string, err error) {
+	if mt.cipher == nil {
+		err = mt.newError("未设置加密方法")
+		return
+	}
+	fields := make([]FieldInfo, len(plaintexts))
+	for i := range plaintexts {
+		if err = mt.checkPrimaryKey(mtTag, primaryKeys[i]); err != nil {
+			return
+		}
+		fields[i] = fieldInfo(ctx, mtTag, primaryKeys[i])
+	}
+	if batch, ok := mt.cipher.(BatchFieldCipher); ok && len(plaintexts) > 1 {
+		cipherTxts, err = batch.EncryptBatch(ctx, fields, plaintexts)
+		if err == nil && len(cipherTxts) != len(plaintexts) {
+			err = errors.New("批量加密结果数量不一致")
+		}
+	} else {
+		cipherTxts = make([]string, len(plaintexts))
+		for i, plaintext := range plaintexts {
+			cipherTxts[i], err = mt.cipher.Encrypt(ctx, fields[i], plaintext)
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		err = mt.newError("加密异常：" + err.Error())
+		return
+	}
+	// 记录明文及密文，打印 SQL 时脱敏
+	for i, plaintext := range plaintexts {
+		addRedactValue(ctx, plaintext, Mask(mtTag.Mask, plaintext))
+		addRedactValue(ctx, cipherTxts[i], redactedValue)
+	}
+	return
+}
@@ -0,0 +1,177 @@
+/**
+ * @Time    :2023/7/3 14:25
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package plugin
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+const (
+	// 密文存储在文本字段中
+	storageText = "text"
+	// 密文存储在二进制字段中
+	storageBinary = "binary"
+)
+
+// 加密前时间类型的序列化格式
+const timeLayout = time.RFC3339Nano
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// marshalFieldValue
+/**
+ *  @Description: 将字段值序列化为待加密的明文
+ *  @param rv
+ *  @return data
+ *  @return err
+ */
+func marshalFieldValue(rv reflect.Value) (data string, err error) {
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return
+	}
+	if rv.Type().ConvertibleTo(timeType) {
+		data = rv.Convert(timeType).Interface().(time.Time).Format(timeLayout)
+		return
+	}
+	switch rv.Kind() {
+	case reflect.String:
+		data = rv.String()
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() != reflect.Uint8 {
+			err = errors.New(rv.Type().String() + "类型不支持加密")
+			return
+		}
+		// []byte、json.RawMessage、datatypes.JSON 等
+		data = string(rv.Bytes())
+	case reflect.Bool:
+		data = strconv.FormatBool(rv.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		data = strconv.FormatInt(rv.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		data = strconv.FormatUint(rv.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		data = strconv.FormatFloat(rv.Float(), 'g', -1, rv.Type().Bits())
+	default:
+		err = errors.New(rv.Type().String() + "类型不支持加密")
+	}
+	return
+}
+
+// unmarshalFieldValue
+/**
+ *  @Description: 将解密后的明文还原为字段原始类型
+ *  @param data
+ *  @param typ
+ *  @return rv
+ *  @return err
+ */
+func unmarshalFieldValue(data string, typ reflect.Type) (rv reflect.Value, err error) {
+	if typ.Kind() == reflect.Ptr {
+		var elem reflect.Value
+		elem, err = unmarshalFieldValue(data, typ.Elem())
+		if err != nil {
+			return
+		}
+		rv = reflect.New(typ.Elem())
+		rv.Elem().Set(elem)
+		return
+	}
+	rv = reflect.New(typ).Elem()
+	if data == "" {
+		return
+	}
+	if timeType.ConvertibleTo(typ) {
+		var t time.Time
+		t, err = time.Parse(timeLayout, data)
+		if err != nil {
+			return
+		}
+		rv.Set(reflect.ValueOf(t).Convert(typ))
+		return
+	}
+	switch typ.Kind() {
+	case reflect.String:
+		rv.SetString(data)
+	case reflect.Slice:
+		if typ.Elem().Kind() != reflect.Uint8 {
+			err = errors.New(typ.String() + "类型不支持解密")
+			return
+		}
+		rv.SetBytes([]byte(data))
+	case reflect.Bool:
+		var b bool
+		b, err = strconv.ParseBool(data)
+		rv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		i, err = strconv.ParseInt(data, 10, typ.Bits())
+		rv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var u uint64
+		u, err = strconv.ParseUint(data, 10, typ.Bits())
+		rv.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		f, err = strconv.ParseFloat(data, typ.Bits())
+		rv.SetFloat(f)
+	default:
+		err = errors.New(typ.String() + "类型不支持解密")
+	}
+	return
+}
+
+// canHoldCipherTxt
+/**
+ *  @Description: 字段类型是否能直接存放密文（string、[]byte）
+ *  @param typ
+ *  @return bool
+ */
+func canHoldCipherTxt(typ reflect.Type) bool {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch typ.Kind() {
+	case reflect.String:
+		return true
+	case reflect.Slice:
+		return typ.Elem().Kind() == reflect.Uint8
+	}
+	return false
+}
+
+// cipherTxtOf
+/**
+ *  @Description: 将数据库中读取的值转换为密文字符串
+ *  @param dbValue
+ *  @return cipherTxt
+ *  @return err
+ */
+func cipherTxtOf(dbValue interface{}) (cipherTxt string, err error) {
+	switch v := dbValue.(type) {
+	case nil:
+	case string:
+		cipherTxt = v
+	case []byte:
+		cipherTxt = string(v)
+	default:
+		rv := reflect.Indirect(reflect.ValueOf(dbValue))
+		if rv.IsValid() && canHoldCipherTxt(rv.Type()) {
+			// 以 string、[]byte 为底层类型的自定义类型
+			return marshalFieldValue(rv)
+		}
+		err = errors.New(reflect.TypeOf(dbValue).String() + "类型密文无法解析")
+	}
+	return
+}
@@ -0,0 +1,50 @@
+/**
+ * @Time    :2023/7/3 15:10
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package plugin
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEncryptValueRoundTrip(t *testing.T) {
+	aead, err := NewAEADCipher([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	birthday := time.Date(2000, 1, 2, 3, 4, 5, 6, time.UTC)
+	values := []interface{}{"13800000000", int64(-42), uint8(7), 3.5, true, birthday, []byte("raw")}
+	for _, cipher := range []FieldCipher{nil, aead} {
+		mt, _ := newTestDB(t, cipher)
+		for _, storage := range []string{storageText, storageBinary} {
+			for _, value := range values {
+				name := reflect.TypeOf(mt.cipher).String() + "/" + storage + "/" + reflect.TypeOf(value).String()
+				t.Run(name, func(t *testing.T) {
+					ctx := context.Background()
+					mtTag := MultiTenancyTag{Table: "users", DBName: "col", Encrypt: true, Storage: storage}
+					cipherValue, err := mt.encryptValue(ctx, mtTag, "", value)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if storage == storageBinary {
+						if _, ok := cipherValue.([]byte); !ok {
+							t.Fatalf("binary storage got %T", cipherValue)
+						}
+					}
+					got, err := mt.decryptValue(ctx, mtTag, "", cipherValue, reflect.TypeOf(value))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !reflect.DeepEqual(got.Interface(), value) {
+						t.Errorf("got %#v, want %#v", got.Interface(), value)
+					}
+				})
+			}
+		}
+	}
+}
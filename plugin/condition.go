@@ -0,0 +1,505 @@
+/**
+ * @Time    :2023/7/12 16:05
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package plugin
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// 匹配 SQL 片段中的 字段 + 运算符 + 占位符（? 或 @命名参数），字段支持表名前缀及反引号、双引号
+var conditionRegexp = regexp.MustCompile("(?i)(?:[`\"]?\\w+[`\"]?\\.)?[`\"]?(\\w+)[`\"]?\\s*(!=|<>|>=|<=|=|>|<|\\bnot\\s+in\\b|\\bin\\b|\\bnot\\s+like\\b|\\blike\\b|\\bis\\b|\\bbetween\\b|\\bregexp\\b)(\\s*\\(?\\s*(?:\\?|@(\\w+)))?")
+
+// encryptBySql
+/**
+ *  @Description: 加密Sql中加密字段的查询条件（WHERE、SET、ON CONFLICT），语句执行后还原
+ *  @receiver mt
+ *  @param db
+ */
+func (mt *MultiTenancy) encryptBySql(db *gorm.DB) {
+	if db.Error != nil {
+		return
+	}
+	if db.Statement.Schema == nil {
+		return
+	}
+	// 若无需要加密的字段，则不需要解析SQL
+	tags := mt.analyzeSchema(db.Statement.Schema)
+	if len(tags.encryptFields) == 0 {
+		return
+	}
+	for _, name := range []string{"WHERE", "SET", "ON CONFLICT"} {
+		c, ok := db.Statement.Clauses[name]
+		if !ok || c.Expression == nil {
+			continue
+		}
+		var expr clause.Expression
+		switch exprType := c.Expression.(type) {
+		case clause.Where:
+			var exprs []clause.Expression
+			exprs, db.Error = mt.encryptExprs(db, tags, exprType.Exprs)
+			expr = clause.Where{Exprs: exprs}
+		case clause.Set:
+			if exprType, db.Error = mt.indexSet(db, tags, exprType); db.Error != nil {
+				return
+			}
+			expr, db.Error = mt.encryptSet(db, tags, exprType)
+		case clause.OnConflict:
+			for _, assignment := range exprType.DoUpdates {
+				if tags.tagMap[assignment.Column.Name].Bind {
+					// 冲突的行与插入的行主键可能不同
+					db.Error = mt.newError(assignment.Column.Name + "字段绑定了所在行的主键，不支持 ON CONFLICT 更新")
+					return
+				}
+			}
+			if exprType.DoUpdates, db.Error = mt.indexSet(db, tags, exprType.DoUpdates); db.Error != nil {
+				return
+			}
+			exprType.DoUpdates, db.Error = mt.encryptSet(db, tags, exprType.DoUpdates)
+			expr = exprType
+		default:
+			continue
+		}
+		if db.Error != nil {
+			return
+		}
+		// 替换为加密后的子句，不修改原有子句，语句执行后还原
+		original := c
+		c.Expression = expr
+		db.Statement.Clauses[name] = c
+		clauseName := name
+		mt.addRestore(db, func() { db.Statement.Clauses[clauseName] = original })
+	}
+	return
+}
+
+// encryptExprs
+/**
+ *  @Description: 加密条件表达式
+ *  @receiver mt
+ *  @param db
+ *  @param tags
+ *  @param exprs
+ *  @return newExprs
+ *  @return err
+ */
+func (mt *MultiTenancy) encryptExprs(db *gorm.DB, tags *modelTags, exprs []clause.Expression) (newExprs []clause.Expression, err error) {
+	newExprs = make([]clause.Expression, len(exprs))
+	for i, expr := range exprs {
+		newExprs[i], err = mt.encryptExpr(db, tags, expr)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// encryptExpr
+/**
+ *  @Description: 加密单个条件表达式
+ *  @receiver mt
+ *  @param db
+ *  @param tags
+ *  @param expr
+ *  @return newExpr
+ *  @return err
+ */
+func (mt *MultiTenancy) encryptExpr(db *gorm.DB, tags *modelTags, expr clause.Expression) (newExpr clause.Expression, err error) {
+	newExpr = expr
+	switch exprType := expr.(type) {
+	case clause.Eq:
+		column := mt.columnName(exprType.Column)
+		if indexColumn := tags.indexColumn(column); indexColumn != "" {
+			// 改写为查询索引字段
+			exprType.Column = replaceColumn(exprType.Column, indexColumn)
+			exprType.Value, err = mt.indexVar(db, tags, column, exprType.Value)
+			newExpr = exprType
+		} else if tags.needEncrypt(column) {
+			if err = mt.randomizedCondition(tags, column); err != nil {
+				return
+			}
+			exprType.Value, err = mt.encryptVar(db, tags, column, exprType.Value)
+			newExpr = exprType
+		}
+	case clause.Neq:
+		column := mt.columnName(exprType.Column)
+		if indexColumn := tags.indexColumn(column); indexColumn != "" {
+			// 改写为查询索引字段
+			exprType.Column = replaceColumn(exprType.Column, indexColumn)
+			exprType.Value, err = mt.indexVar(db, tags, column, exprType.Value)
+			newExpr = exprType
+		} else if tags.needEncrypt(column) {
+			if err = mt.randomizedCondition(tags, column); err != nil {
+				return
+			}
+			exprType.Value, err = mt.encryptVar(db, tags, column, exprType.Value)
+			newExpr = exprType
+		}
+	case clause.IN:
+		column := mt.columnName(exprType.Column)
+		if indexColumn := tags.indexColumn(column); indexColumn != "" {
+			exprType.Column = replaceColumn(exprType.Column, indexColumn)
+			values := make([]interface{}, len(exprType.Values))
+			for i, value := range exprType.Values {
+				values[i], err = mt.indexVar(db, tags, column, value)
+				if err != nil {
+					return
+				}
+			}
+			exprType.Values = values
+			newExpr = exprType
+		} else if tags.needEncrypt(column) {
+			if err = mt.randomizedCondition(tags, column); err != nil {
+				return
+			}
+			values := make([]interface{}, len(exprType.Values))
+			for i, value := range exprType.Values {
+				values[i], err = mt.encryptVar(db, tags, column, value)
+				if err != nil {
+					return
+				}
+			}
+			exprType.Values = values
+			newExpr = exprType
+		}
+	case clause.Gt:
+		err = mt.unsupportedCondition(tags, exprType.Column)
+	case clause.Gte:
+		err = mt.unsupportedCondition(tags, exprType.Column)
+	case clause.Lt:
+		err = mt.unsupportedCondition(tags, exprType.Column)
+	case clause.Lte:
+		err = mt.unsupportedCondition(tags, exprType.Column)
+	case clause.Like:
+		err = mt.unsupportedCondition(tags, exprType.Column)
+	case clause.AndConditions:
+		exprType.Exprs, err = mt.encryptExprs(db, tags, exprType.Exprs)
+		newExpr = exprType
+	case clause.OrConditions:
+		exprType.Exprs, err = mt.encryptExprs(db, tags, exprType.Exprs)
+		newExpr = exprType
+	case clause.NotConditions:
+		exprType.Exprs, err = mt.encryptExprs(db, tags, exprType.Exprs)
+		newExpr = exprType
+	case clause.Expr:
+		exprType.SQL, exprType.Vars, err = mt.encryptSqlVars(db, tags, exprType.SQL, exprType.Vars, false)
+		newExpr = exprType
+	case clause.NamedExpr:
+		exprType.SQL, exprType.Vars, err = mt.encryptSqlVars(db, tags, exprType.SQL, exprType.Vars, true)
+		newExpr = exprType
+	}
+	return
+}
+
+// encryptSqlVars
+/**
+ *  @Description: 解析 SQL 片段，加密加密字段对应的参数，声明了查询索引的字段改写为查询索引字段；无法确定参数时返回错误，避免明文查询
+ *  @receiver mt
+ *  @param db
+ *  @param tags
+ *  @param sql
+ *  @param vars
+ *  @param named 是否为命名参数表达式（@name）
+ *  @return newSql
+ *  @return newVars
+ *  @return err
+ */
+func (mt *MultiTenancy) encryptSqlVars(db *gorm.DB, tags *modelTags, sql string, vars []interface{}, named bool) (newSql string, newVars []interface{}, err error) {
+	newSql, newVars = sql, vars
+	if len(vars) == 0 {
+		return
+	}
+	// 改写为查询索引字段的字段名位置，按出现顺序排列
+	var indexes [][2]int
+	var copied bool
+	// 已加密的命名参数，同一参数可能被多次引用
+	encryptedNames := make(map[string]bool)
+	outside := outsideQuotes(sql)
+	matches := conditionRegexp.FindAllStringSubmatchIndex(sql, -1)
+	for _, match := range matches {
+		if !outside[match[4]] {
+			// 引号内的字符串常量
+			continue
+		}
+		column := sql[match[2]:match[3]]
+		if !tags.needEncrypt(column) {
+			continue
+		}
+		operator := strings.ToLower(strings.Join(strings.Fields(sql[match[4]:match[5]]), " "))
+		switch operator {
+		case "=", "!=", "<>", "in", "not in":
+		case "is":
+			// IS NULL、IS NOT NULL
+			continue
+		default:
+			err = mt.newError(column + "字段已经开启加密，仅支持精确匹配查询")
+			return
+		}
+		if match[6] < 0 {
+			// 非占位符参数（如字段比较）无需加密
+			if err = mt.randomizedCondition(tags, column); err != nil {
+				return
+			}
+			continue
+		}
+		// 声明了查询索引的字段按索引查询，否则加密参数
+		convert := func(value interface{}) (interface{}, error) {
+			return mt.encryptVar(db, tags, column, value)
+		}
+		if indexColumn := tags.indexColumn(column); indexColumn != "" {
+			indexes = append(indexes, [2]int{match[2], match[3]})
+			convert = func(value interface{}) (interface{}, error) {
+				return mt.indexVar(db, tags, column, value)
+			}
+		} else if err = mt.randomizedCondition(tags, column); err != nil {
+			return
+		}
+		if !copied {
+			// 复制一份，避免修改调用方传入的参数
+			newVars = append([]interface{}{}, vars...)
+			copied = true
+		}
+		if match[8] >= 0 {
+			if !named {
+				// 非命名参数表达式中的 @name 为 SQL 变量，不绑定参数
+				continue
+			}
+			name := sql[match[8]:match[9]]
+			if encryptedNames[name] {
+				continue
+			}
+			encryptedNames[name] = true
+			if err = mt.convertNamedVar(column, name, newVars, convert); err != nil {
+				return
+			}
+			continue
+		}
+		// 该字段对应的?的索引，引号内的?不是占位符
+		index := -1
+		for i := 0; i < match[7]; i++ {
+			if sql[i] == '?' && outside[i] {
+				index++
+			}
+		}
+		if index >= len(vars) {
+			err = mt.newError(column + "字段的查询条件缺少参数，无法加密")
+			return
+		}
+		newVars[index], err = convert(vars[index])
+		if err != nil {
+			return
+		}
+	}
+	// 从后向前替换字段名，避免位置偏移
+	for i := len(indexes) - 1; i >= 0; i-- {
+		start, end := indexes[i][0], indexes[i][1]
+		newSql = newSql[:start] + tags.indexColumn(sql[start:end]) + newSql[end:]
+	}
+	return
+}
+
+// convertNamedVar
+/**
+ *  @Description: 加密（或计算查询索引）命名参数（sql.NamedArg、map），与 GORM 相同，后出现的同名参数生效
+ *  @receiver mt
+ *  @param column
+ *  @param name 参数名
+ *  @param vars 已复制的参数，原地替换
+ *  @param convert 加密或计算查询索引
+ *  @return err
+ */
+func (mt *MultiTenancy) convertNamedVar(column string, name string, vars []interface{}, convert func(value interface{}) (interface{}, error)) (err error) {
+	for i := len(vars) - 1; i >= 0; i-- {
+		switch value := vars[i].(type) {
+		case sql.NamedArg:
+			if value.Name != name {
+				continue
+			}
+			value.Value, err = convert(value.Value)
+			vars[i] = value
+			return
+		case map[string]interface{}:
+			v, ok := value[name]
+			if !ok {
+				continue
+			}
+			// 复制一份，避免修改调用方传入的 map
+			values := make(map[string]interface{}, len(value))
+			for key, mapValue := range value {
+				values[key] = mapValue
+			}
+			values[name], err = convert(v)
+			vars[i] = values
+			return
+		default:
+			rv := reflect.Indirect(reflect.ValueOf(value))
+			if rv.Kind() == reflect.Struct && rv.FieldByName(name).IsValid() {
+				err = mt.newError(column + "字段的命名参数" + name + "来自结构体，无法加密，请使用 sql.Named 或 map 传参")
+				return
+			}
+		}
+	}
+	return
+}
+
+// outsideQuotes
+/**
+ *  @Description: 标记 SQL 中各字节是否位于引号（'、"、`）之外，支持重复引号及反斜杠转义
+ *  @param sql
+ *  @return outside
+ */
+func outsideQuotes(sql string) (outside []bool) {
+	outside = make([]bool, len(sql)+1)
+	var quote byte
+	for i := 0; i < len(sql); i++ {
+		switch {
+		case quote == 0:
+			if sql[i] == '\'' || sql[i] == '"' || sql[i] == '`' {
+				quote = sql[i]
+			} else {
+				outside[i] = true
+			}
+		case sql[i] == '\\' && quote != '`':
+			// 跳过转义字符
+			i++
+		case sql[i] == quote:
+			if i+1 < len(sql) && sql[i+1] == quote {
+				// 重复引号转义
+				i++
+			} else {
+				quote = 0
+			}
+		}
+	}
+	outside[len(sql)] = quote == 0
+	return
+}
+
+// encryptSet
+/**
+ *  @Description: 加密 SET 子句中加密字段的值
+ *  @receiver mt
+ *  @param db
+ *  @param tags
+ *  @param set
+ *  @return newSet
+ *  @return err
+ */
+func (mt *MultiTenancy) encryptSet(db *gorm.DB, tags *modelTags, set clause.Set) (newSet clause.Set, err error) {
+	newSet = make(clause.Set, len(set))
+	for i, assignment := range set {
+		if tags.needEncrypt(assignment.Column.Name) {
+			assignment.Value, err = mt.encryptVar(db, tags, assignment.Column.Name, assignment.Value)
+			if err != nil {
+				return
+			}
+		}
+		newSet[i] = assignment
+	}
+	return
+}
+
+// encryptVar
+/**
+ *  @Description: 加密参数，切片逐个加密，SQL 表达式、子查询等保持不变
+ *  @receiver mt
+ *  @param db
+ *  @param tags
+ *  @param column
+ *  @param value
+ *  @return cipherValue
+ *  @return err
+ */
+func (mt *MultiTenancy) encryptVar(db *gorm.DB, tags *modelTags, column string, value interface{}) (cipherValue interface{}, err error) {
+	// 绑定主键的字段（仅用于更新）使用语句操作的单行主键
+	return mt.encryptRowVar(db, tags, column, primaryKeyFromContext(db.Statement.Context), value)
+}
+
+// encryptRowVar
+/**
+ *  @Description: 同 encryptVar，绑定主键的字段使用指定的主键
+ *  @receiver mt
+ *  @param db
+ *  @param tags
+ *  @param column
+ *  @param primaryKey 所在行的主键
+ *  @param value
+ *  @return cipherValue
+ *  @return err
+ */
+func (mt *MultiTenancy) encryptRowVar(db *gorm.DB, tags *modelTags, column string, primaryKey string, value interface{}) (cipherValue interface{}, err error) {
+	switch value.(type) {
+	case nil, clause.Expr, clause.NamedExpr, clause.Column, clause.Expression, *gorm.DB, sql.NamedArg:
+		return value, nil
+	case driver.Valuer:
+		// 结构体条件中使用加密序列化器的字段已在 Value 时加密
+		if field := db.Statement.Schema.LookUpField(column); field != nil && field.Serializer != nil {
+			return value, nil
+		}
+	}
+	rv := reflect.ValueOf(value)
+	if (rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() != reflect.Uint8) || rv.Kind() == reflect.Array {
+		values := make([]interface{}, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			values[i], err = mt.encryptRowVar(db, tags, column, primaryKey, rv.Index(i).Interface())
+			if err != nil {
+				return
+			}
+		}
+		return values, nil
+	}
+	return mt.encryptValue(db.Statement.Context, tags.tagMap[column], primaryKey, value)
+}
+
+// replaceColumn
+/**
+ *  @Description: 替换条件表达式中的字段名，保留表名
+ *  @param column string 或 clause.Column
+ *  @param name
+ *  @return interface{}
+ */
+func replaceColumn(column interface{}, name string) interface{} {
+	if c, ok := column.(clause.Column); ok {
+		c.Name = name
+		return c
+	}
+	return name
+}
+
+// randomizedCondition
+/**
+ *  @Description: 随机加密的字段不支持作为查询条件
+ *  @receiver mt
+ *  @param tags
+ *  @param column
+ *  @return err
+ */
+func (mt *MultiTenancy) randomizedCondition(tags *modelTags, column string) (err error) {
+	if mt.isRandomized(tags.tagMap[column]) {
+		err = mt.newError(column + "字段为随机加密，无法作为查询条件，如需精确匹配查询请声明 mode:" + ModeDeterministic)
+	}
+	return
+}
+
+// unsupportedCondition
+/**
+ *  @Description: 加密字段不支持范围、模糊查询
+ *  @receiver mt
+ *  @param tags
+ *  @param column
+ *  @return err
+ */
+func (mt *MultiTenancy) unsupportedCondition(tags *modelTags, column interface{}) (err error) {
+	if name := mt.columnName(column); tags.needEncrypt(name) {
+		err = mt.newError(name + "字段已经开启加密，仅支持精确匹配查询")
+	}
+	return
+}
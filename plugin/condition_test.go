@@ -0,0 +1,222 @@
+/**
+ * @Time    :2023/7/12 17:20
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package plugin
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+type testUser struct {
+	ID       int64
+	Name     string
+	Phone    string    `mt:"encrypt"`
+	Balance  int64     `mt:"encrypt" gorm:"type:varchar(255);serializer:mt_encrypt"`
+	Birthday time.Time `mt:"encrypt" gorm:"type:varchar(255);serializer:mt_encrypt"`
+}
+
+// newTestDB
+/**
+ *  @Description: 创建不连接数据库的 DryRun 会话，并注册插件
+ *  @param t
+ *  @param cipher 字段加解密实现，为 nil 时使用 testEncrypt、testDecrypt
+ *  @return mt
+ *  @return db
+ */
+func newTestDB(t *testing.T, cipher FieldCipher) (mt *MultiTenancy, db *gorm.DB) {
+	t.Helper()
+	db, err := gorm.Open(mysql.New(mysql.Config{
+		DSN:                       "test:test@tcp(127.0.0.1:3306)/test",
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mt = &MultiTenancy{}
+	mt.Register("", nil)
+	if err = db.Use(mt); err != nil {
+		t.Fatal(err)
+	}
+	if cipher == nil {
+		mt.SetEncryptedSave(testEncrypt, testDecrypt)
+	} else {
+		mt.SetFieldCipher(cipher)
+	}
+	return
+}
+
+// testStatement
+/**
+ *  @Description: 解析模型，返回可用于加密条件的会话
+ *  @param t
+ *  @param db
+ *  @param model
+ *  @return tx
+ *  @return tags
+ */
+func testStatement(t *testing.T, mt *MultiTenancy, db *gorm.DB, model interface{}) (tx *gorm.DB, tags *modelTags) {
+	t.Helper()
+	tx = db.Session(&gorm.Session{NewDB: true, Context: context.Background()})
+	if err := tx.Statement.Parse(model); err != nil {
+		t.Fatal(err)
+	}
+	return tx, mt.analyzeSchema(tx.Statement.Schema)
+}
+
+func testEncrypt(data string) (string, error) {
+	return "E:" + data, nil
+}
+
+func testDecrypt(cipherTxt string) (string, error) {
+	if !strings.HasPrefix(cipherTxt, "E:") {
+		return "", errors.New("not cipher text")
+	}
+	return cipherTxt[2:], nil
+}
+
+func TestEncryptSqlVars(t *testing.T) {
+	mt, db := newTestDB(t, nil)
+	tx, tags := testStatement(t, mt, db, &testUser{})
+	tests := []struct {
+		name    string
+		sql     string
+		vars    []interface{}
+		named   bool
+		want    []interface{}
+		wantErr bool
+	}{
+		{name: "eq", sql: "phone = ?", vars: []interface{}{"138"}, want: []interface{}{"E:138"}},
+		{name: "table prefix", sql: "users.phone = ?", vars: []interface{}{"138"}, want: []interface{}{"E:138"}},
+		{name: "backquote", sql: "`users`.`phone` <> ?", vars: []interface{}{"138"}, want: []interface{}{"E:138"}},
+		{name: "double quote", sql: `"users"."phone" != ?`, vars: []interface{}{"138"}, want: []interface{}{"E:138"}},
+		{name: "in", sql: "phone IN ?", vars: []interface{}{[]string{"1", "2"}}, want: []interface{}{[]interface{}{"E:1", "E:2"}}},
+		{name: "in parentheses", sql: "phone in (?)", vars: []interface{}{[]string{"1"}}, want: []interface{}{[]interface{}{"E:1"}}},
+		{name: "not in", sql: "`phone` NOT  IN (?)", vars: []interface{}{[]string{"1"}}, want: []interface{}{[]interface{}{"E:1"}}},
+		{name: "plain column", sql: "name = ? AND phone = ?", vars: []interface{}{"a", "138"}, want: []interface{}{"a", "E:138"}},
+		{name: "is null", sql: "phone IS NULL AND name = ?", vars: []interface{}{"a"}, want: []interface{}{"a"}},
+		{name: "is not null", sql: "phone IS NOT NULL", vars: nil, want: nil},
+		{name: "column comparison", sql: "phone = name AND phone = ?", vars: []interface{}{"138"}, want: []interface{}{"E:138"}},
+		{name: "nil value", sql: "phone = ?", vars: []interface{}{nil}, want: []interface{}{nil}},
+		{name: "serializer field", sql: "balance = ?", vars: []interface{}{int64(10)}, want: []interface{}{"E:10"}},
+		{name: "like", sql: "phone LIKE ?", vars: []interface{}{"%1%"}, wantErr: true},
+		{name: "range", sql: "phone > ?", vars: []interface{}{"1"}, wantErr: true},
+		{name: "between", sql: "phone BETWEEN ? AND ?", vars: []interface{}{"1", "2"}, wantErr: true},
+		{name: "quoted placeholder", sql: "name = 'a?' AND phone = ?", vars: []interface{}{"1"}, want: []interface{}{"E:1"}},
+		{name: "escaped quote", sql: `name = 'it''s \' ?' AND phone = ?`, vars: []interface{}{"1"}, want: []interface{}{"E:1"}},
+		{name: "quoted condition", sql: "name = 'phone = ?' AND name = ?", vars: []interface{}{"a"}, want: []interface{}{"a"}},
+		{name: "missing var", sql: "name = ? AND phone = ?", vars: []interface{}{"a"}, wantErr: true},
+		{name: "sql variable", sql: "phone = @p AND name = ?", vars: []interface{}{"a"}, want: []interface{}{"a"}},
+		{name: "named arg", sql: "phone = @p AND name = @n", named: true, vars: []interface{}{sql.Named("p", "1"), sql.Named("n", "a")}, want: []interface{}{sql.Named("p", "E:1"), sql.Named("n", "a")}},
+		{name: "named arg reused", sql: "phone = @p OR phone IN (@p)", named: true, vars: []interface{}{sql.Named("p", "1")}, want: []interface{}{sql.Named("p", "E:1")}},
+		{name: "named map", sql: "(phone = @p)", named: true, vars: []interface{}{map[string]interface{}{"p": "1"}}, want: []interface{}{map[string]interface{}{"p": "E:1"}}},
+		{name: "named struct", sql: "phone = @Phone", named: true, vars: []interface{}{testUser{Phone: "1"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var original []interface{}
+			original = append(original, tt.vars...)
+			_, got, err := mt.encryptSqlVars(tx, tags, tt.sql, tt.vars, tt.named)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+			if !reflect.DeepEqual(tt.vars, original) {
+				t.Errorf("vars modified: %#v", tt.vars)
+			}
+		})
+	}
+}
+
+func TestEncryptNamedCondition(t *testing.T) {
+	_, db := newTestDB(t, nil)
+	params := map[string]interface{}{"p": "138"}
+	tests := []struct {
+		name string
+		exec func(tx *gorm.DB) *gorm.DB
+	}{
+		{name: "named arg", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Where("phone = @p", sql.Named("p", "138")).Find(&[]testUser{})
+		}},
+		{name: "map", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Where("phone = @p", params).Find(&[]testUser{})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := tt.exec(db.Session(&gorm.Session{NewDB: true, Context: context.Background()}))
+			if tx.Error != nil {
+				t.Fatal(tx.Error)
+			}
+			if !reflect.DeepEqual(tx.Statement.Vars, []interface{}{"E:138"}) {
+				t.Errorf("vars = %#v", tx.Statement.Vars)
+			}
+			if params["p"] != "138" {
+				t.Errorf("params modified: %#v", params)
+			}
+		})
+	}
+}
+
+func TestEncryptMapWrite(t *testing.T) {
+	_, db := newTestDB(t, nil)
+	tests := []struct {
+		name   string
+		values map[string]interface{}
+		exec   func(tx *gorm.DB, values map[string]interface{}) *gorm.DB
+	}{
+		{name: "update field name", exec: func(tx *gorm.DB, values map[string]interface{}) *gorm.DB {
+			return tx.Model(&testUser{ID: 1}).Update("Phone", "138")
+		}},
+		{name: "updates field name", values: map[string]interface{}{"Phone": "138"}, exec: func(tx *gorm.DB, values map[string]interface{}) *gorm.DB {
+			return tx.Model(&testUser{ID: 1}).Updates(values)
+		}},
+		{name: "updates column name", values: map[string]interface{}{"phone": "138"}, exec: func(tx *gorm.DB, values map[string]interface{}) *gorm.DB {
+			return tx.Model(&testUser{ID: 1}).Updates(values)
+		}},
+		{name: "create map", values: map[string]interface{}{"ID": 1, "Phone": "138"}, exec: func(tx *gorm.DB, values map[string]interface{}) *gorm.DB {
+			return tx.Model(&testUser{}).Create(values)
+		}},
+		{name: "create map slice", values: map[string]interface{}{"id": 1, "phone": "138"}, exec: func(tx *gorm.DB, values map[string]interface{}) *gorm.DB {
+			return tx.Model(&testUser{}).Create(&[]map[string]interface{}{values})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := tt.exec(db.Session(&gorm.Session{NewDB: true, Context: context.Background()}), tt.values)
+			if tx.Error != nil {
+				t.Fatal(tx.Error)
+			}
+			var encrypted bool
+			for _, v := range tx.Statement.Vars {
+				if v == "138" {
+					t.Fatalf("plaintext in vars %#v", tx.Statement.Vars)
+				}
+				encrypted = encrypted || v == "E:138"
+			}
+			if !encrypted {
+				t.Fatalf("no cipher text in vars %#v", tx.Statement.Vars)
+			}
+			for key, v := range tt.values {
+				if strings.EqualFold(key, "phone") && v != "138" {
+					t.Errorf("map value not restored: %#v", v)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+/**
+ * @Time    :2023/7/19 10:15
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package plugin
+
+import "context"
+
+type tenantContextKey struct{}
+
+// WithTenantId
+/**
+ *  @Description: 在 Context 中记录租户ID，用于选择租户的数据密钥
+ *  @param ctx
+ *  @param tenantId
+ *  @return context.Context
+ */
+func WithTenantId(ctx context.Context, tenantId string) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, tenantContextKey{}, tenantId)
+}
+
+// TenantIdFromContext
+/**
+ *  @Description: 获取 Context 中记录的租户ID
+ *  @param ctx
+ *  @return tenantId
+ */
+func TenantIdFromContext(ctx context.Context) (tenantId string) {
+	if ctx == nil {
+		return
+	}
+	tenantId, _ = ctx.Value(tenantContextKey{}).(string)
+	return
+}
+
+type primaryKeyContextKey struct{}
+
+// withPrimaryKey
+/**
+ *  @Description: 在 Context 中记录语句操作的单行主键，用于加密序列化器加密绑定主键的字段
+ *  @param ctx
+ *  @param primaryKey
+ *  @return context.Context
+ */
+func withPrimaryKey(ctx context.Context, primaryKey string) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, primaryKeyContextKey{}, primaryKey)
+}
+
+// primaryKeyFromContext
+/**
+ *  @Description: 获取 Context 中记录的语句操作的单行主键
+ *  @param ctx
+ *  @return primaryKey
+ */
+func primaryKeyFromContext(ctx context.Context) (primaryKey string) {
+	if ctx == nil {
+		return
+	}
+	primaryKey, _ = ctx.Value(primaryKeyContextKey{}).(string)
+	return
+}
@@ -0,0 +1,302 @@
+/**
+ * @Time    :2023/7/14 10:32
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package plugin
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"strings"
+)
+
+// DecryptDest
+/**
+ *  @Description: 解密不经过查询回调的结果（Scan、Rows），按模型中加密字段的字段名解密
+ *  @receiver mt
+ *  @param model 模型，如 &User{}
+ *  @param dest 查询结果，支持结构体、Map 及其切片，基础类型切片需指定查询的字段
+ *  @param columns 查询的字段
+ *  @return err
+ */
+func (mt *MultiTenancy) DecryptDest(model interface{}, dest interface{}, columns ...string) (err error) {
+	return mt.DecryptDestContext(context.Background(), model, dest, columns...)
+}
+
+// DecryptDestContext
+/**
+ *  @Description: 同 DecryptDest，使用信封加密时通过 ctx 指定租户（WithTenantId）
+ *  @receiver mt
+ *  @param ctx
+ *  @param model 模型，如 &User{}
+ *  @param dest 查询结果
+ *  @param columns 查询的字段
+ *  @return err
+ */
+func (mt *MultiTenancy) DecryptDestContext(ctx context.Context, model interface{}, dest interface{}, columns ...string) (err error) {
+	// 若未开启加密保存，则不执行后续操作
+	if !mt.encryptedSave {
+		return
+	}
+	tx := mt.DB.Session(&gorm.Session{NewDB: true, Context: ctx})
+	err = tx.Statement.Parse(model)
+	if err != nil {
+		return
+	}
+	rv := reflect.ValueOf(dest)
+	if rv.Kind() != reflect.Ptr && rv.Kind() != reflect.Map {
+		err = mt.newError("dest 须为指针")
+		return
+	}
+	return mt.decryptDest(tx, tx.Statement.Schema, rv, columns)
+}
+
+// decryptDest
+/**
+ *  @Description: 按模型中加密字段的字段名解密查询结果
+ *  @receiver mt
+ *  @param db
+ *  @param sch 模型
+ *  @param rv 查询结果
+ *  @param columns 查询的字段
+ *  @return err
+ */
+func (mt *MultiTenancy) decryptDest(db *gorm.DB, sch *schema.Schema, rv reflect.Value, columns []string) (err error) {
+	tags := mt.analyzeSchema(sch)
+	if tags.err != nil {
+		return tags.err
+	}
+	if len(tags.encryptFields) == 0 {
+		return
+	}
+	rv = reflect.Indirect(rv)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		elemType := rv.Type().Elem()
+		for elemType.Kind() == reflect.Ptr {
+			elemType = elemType.Elem()
+		}
+		if (elemType.Kind() == reflect.Struct && !timeType.ConvertibleTo(elemType)) || elemType.Kind() == reflect.Map {
+			for i := 0; i < rv.Len(); i++ {
+				err = mt.decryptDest(db, sch, rv.Index(i), columns)
+				if err != nil {
+					return
+				}
+			}
+			return
+		}
+		// 基础类型切片（Pluck），按查询的字段解密
+		if len(columns) != 1 || !tags.needEncrypt(columns[0]) || elemType.Kind() == reflect.Uint8 {
+			return
+		}
+		for i := 0; i < rv.Len(); i++ {
+			elem := reflect.Indirect(rv.Index(i))
+			if !elem.IsValid() {
+				continue
+			}
+			var fieldValue reflect.Value
+			fieldValue, err = mt.decryptValue(db.Statement.Context, tags.tagMap[columns[0]], "", elem.Interface(), elem.Type())
+			if err != nil {
+				return
+			}
+			elem.Set(fieldValue)
+		}
+	case reflect.Struct:
+		if rv.Type() == sch.ModelType {
+			return mt.cryptReflectValue(db, sch, rv, decrypt)
+		}
+		// 查询结果为其他结构体，按字段名匹配加密字段
+		destStmt := &gorm.Statement{DB: db}
+		if destStmt.Parse(reflect.New(rv.Type()).Interface()) != nil {
+			return
+		}
+		for _, field := range destStmt.Schema.Fields {
+			if !tags.needEncrypt(field.DBName) {
+				continue
+			}
+			err = mt.setEncryptData(db, tags.tagMap[field.DBName], field, rv, decrypt)
+			if err != nil {
+				return
+			}
+		}
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return
+		}
+		elemType := rv.Type().Elem()
+		// 绑定主键的字段按所在行的主键解密
+		var primaryKey string
+		if sch.PrioritizedPrimaryField != nil {
+			if pkValue := rv.MapIndex(reflect.ValueOf(sch.PrioritizedPrimaryField.DBName).Convert(rv.Type().Key())); pkValue.IsValid() {
+				primaryKey, _ = marshalFieldValue(pkValue)
+			}
+		}
+		for _, key := range rv.MapKeys() {
+			if !tags.needEncrypt(key.String()) {
+				continue
+			}
+			value := rv.MapIndex(key)
+			if value.Kind() == reflect.Interface {
+				value = value.Elem()
+			}
+			if !value.IsValid() {
+				continue
+			}
+			// 优先还原为模型字段的类型
+			fieldType := tags.tagMap[key.String()].FieldType
+			if fieldType == nil || !fieldType.AssignableTo(elemType) {
+				fieldType = elemType
+			}
+			var fieldValue reflect.Value
+			fieldValue, err = mt.decryptValue(db.Statement.Context, tags.tagMap[key.String()], primaryKey, value.Interface(), fieldType)
+			if err != nil {
+				return
+			}
+			rv.SetMapIndex(key, fieldValue)
+		}
+	}
+	return
+}
+
+// selectColumns
+/**
+ *  @Description: 获取查询的字段名
+ *  @receiver mt
+ *  @param db
+ *  @return columns
+ */
+func (mt *MultiTenancy) selectColumns(db *gorm.DB) (columns []string) {
+	names := db.Statement.Selects
+	if len(names) == 0 {
+		if c, ok := db.Statement.Clauses["SELECT"]; ok {
+			if selectClause, ok := c.Expression.(clause.Select); ok {
+				for _, column := range selectClause.Columns {
+					names = append(names, column.Name)
+				}
+			}
+		}
+	}
+	for _, name := range names {
+		// 去除表名前缀及引号
+		if index := strings.LastIndex(name, "."); index >= 0 {
+			name = name[index+1:]
+		}
+		columns = append(columns, strings.Trim(strings.TrimSpace(name), "`\""))
+	}
+	return
+}
+
+// selectPrimaryKey
+/**
+ *  @Description: 查询绑定主键的字段但未查询主键时，自动查询主键，解密后清除；
+ *  Pluck 等基础类型的查询结果无法获取主键，返回错误
+ *  @receiver mt
+ *  @param db
+ */
+func (mt *MultiTenancy) selectPrimaryKey(db *gorm.DB) {
+	sch := db.Statement.Schema
+	if db.Error != nil || sch == nil || sch.PrioritizedPrimaryField == nil || db.Statement.SQL.Len() > 0 {
+		return
+	}
+	if _, ok := db.Get("rows"); ok {
+		// Row、Rows 的查询结果由调用方读取，不修改查询的字段
+		return
+	}
+	tags := mt.analyzeSchema(sch)
+	if !tags.bind {
+		return
+	}
+	pkName := sch.PrioritizedPrimaryField.DBName
+	var bound string
+	for _, column := range mt.selectColumns(db) {
+		if column == "*" || column == pkName {
+			return
+		}
+		if tags.tagMap[column].Bind && bound == "" {
+			bound = column
+		}
+	}
+	if bound == "" {
+		// 未指定查询的字段时查询全部字段
+		return
+	}
+	if !isStructDest(db.Statement.ReflectValue) {
+		db.Error = mt.newError(bound + "字段绑定了所在行的主键，不支持 Pluck 等基础类型的查询结果，请查询到结构体或 Map")
+		return
+	}
+	if _, ok := db.Statement.Clauses["SELECT"]; ok || db.Statement.Distinct {
+		db.Error = mt.newError(bound + "字段绑定了所在行的主键，DISTINCT 或指定 SELECT 子句时须查询主键")
+		return
+	}
+	column := pkName
+	if len(db.Statement.Joins) > 0 {
+		column = sch.Table + "." + pkName
+	}
+	// 主键在前，加密序列化器解密时主键已赋值
+	selects := db.Statement.Selects
+	db.Statement.Selects = append([]string{column}, selects...)
+	db.Statement.Settings.Store(selectPrimaryKeySettingKey, true)
+	mt.addRestore(db, func() { db.Statement.Selects = selects })
+}
+
+// isStructDest
+/**
+ *  @Description: 查询结果是否为结构体、Map 或其切片
+ *  @param rv
+ *  @return bool
+ */
+func isStructDest(rv reflect.Value) bool {
+	if !rv.IsValid() {
+		return false
+	}
+	typ := rv.Type()
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array {
+		typ = typ.Elem()
+		for typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+	}
+	return (typ.Kind() == reflect.Struct && !timeType.ConvertibleTo(typ)) || typ.Kind() == reflect.Map
+}
+
+// clearPrimaryKey
+/**
+ *  @Description: 清除查询结果中自动查询的主键
+ *  @receiver mt
+ *  @param db
+ *  @param rv
+ */
+func (mt *MultiTenancy) clearPrimaryKey(db *gorm.DB, rv reflect.Value) {
+	pkName := db.Statement.Schema.PrioritizedPrimaryField.DBName
+	rv = reflect.Indirect(rv)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			mt.clearPrimaryKey(db, rv.Index(i))
+		}
+	case reflect.Struct:
+		sch := db.Statement.Schema
+		if rv.Type() != sch.ModelType {
+			// 查询结果为其他结构体，按字段名匹配主键
+			destStmt := &gorm.Statement{DB: db}
+			if destStmt.Parse(reflect.New(rv.Type()).Interface()) != nil {
+				return
+			}
+			sch = destStmt.Schema
+		}
+		if field := sch.LookUpField(pkName); field != nil && rv.CanAddr() {
+			field.ReflectValueOf(db.Statement.Context, rv).Set(reflect.Zero(field.FieldType))
+		}
+	case reflect.Map:
+		if rv.Type().Key().Kind() == reflect.String {
+			rv.SetMapIndex(reflect.ValueOf(pkName).Convert(rv.Type().Key()), reflect.Value{})
+		}
+	}
+}
@@ -0,0 +1,25 @@
+/**
+ * @Time    :2023/7/14 10:30
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package plugin
+
+import (
+	"reflect"
+	"testing"
+)
+
+type columnName string
+
+func TestDecryptNamedKeyMap(t *testing.T) {
+	mt, db := newTestDB(t, nil)
+	tx, _ := testStatement(t, mt, db, &testUser{})
+	row := map[columnName]interface{}{"id": int64(1), "phone": "E:138"}
+	if err := mt.decryptDest(tx, tx.Statement.Schema, reflect.ValueOf(&row), nil); err != nil {
+		t.Fatal(err)
+	}
+	if row["phone"] != "138" {
+		t.Errorf("phone = %#v", row["phone"])
+	}
+}
@@ -7,137 +7,359 @@ package plugin
 
 import (
 	"context"
+	"errors"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 	"gorm.io/gorm/schema"
-	"gorm.io/gorm/utils"
 	"reflect"
-	"strings"
 )
 
-const DefaultTagName = "mt"
-
-type MultiTenancyTag struct {
-	DBName    string
-	FieldName string
-	FieldType reflect.Type
-	tag       string
-	Encrypt   bool
-}
-
 const (
 	encrypt = 1
 	decrypt = 2
 )
 
-// analyzeMTTag
+// 语句中记录待还原明文的 Settings Key
+const restoreSettingKey = "gorm:multi-tenancy-restore"
+
+// 语句中记录 Joins 关联的 Settings Key
+const joinsSettingKey = "gorm:multi-tenancy-joins"
+
+// 语句中记录自动查询主键的 Settings Key
+const selectPrimaryKeySettingKey = "gorm:multi-tenancy-select-primary-key"
+
+// columnName
 /**
- *  @Description: 解析Tag
+ *  @Description: 获取条件中的字段名
  *  @receiver mt
- *  @param tag
- *  @param mtTag
+ *  @param column
+ *  @return name
+ */
+func (mt *MultiTenancy) columnName(column interface{}) (name string) {
+	switch c := column.(type) {
+	case string:
+		name = c
+	case clause.Column:
+		name = c.Name
+	}
+	return
+}
+
+// setEncryptData
+/**
+ *  @Description: 对结构体中直接存放密文的字段加解密
+ *  @receiver mt
+ *  @param db
+ *  @param mtTag 字段所属模型的 mt Tag
+ *  @param field
+ *  @param valueOf
+ *  @param flag
+ *  @return err
  */
-func (mt *MultiTenancy) analyzeMTTag(tag string, mtTag *MultiTenancyTag) {
-	tags := strings.Split(tag, ";")
-	for _, ti := range tags {
-		if strings.Contains(ti, "encrypt") {
-			mtTag.Encrypt = true
+func (mt *MultiTenancy) setEncryptData(db *gorm.DB, mtTag MultiTenancyTag, field *schema.Field, valueOf reflect.Value, flag int) (err error) {
+	ctx := db.Statement.Context
+	// 使用加密序列化器的字段在读写数据库时自行加解密
+	if field.Serializer != nil {
+		return
+	}
+	if !canHoldCipherTxt(field.FieldType) {
+		err = mt.newError(field.Name + "字段无法直接存放密文，请使用 serializer:" + EncryptSerializerName)
+		return
+	}
+	// 获取值
+	fieldValue, isZero := field.ValueOf(ctx, valueOf)
+	if isZero {
+		return
+	}
+	// 绑定主键的字段获取所在行的主键
+	primaryKey := mt.rowPrimaryKey(db, mtTag, field, valueOf)
+	var newValue interface{}
+	switch flag {
+	case encrypt:
+		var cipherValue interface{}
+		// 直接存放密文的字段按字段原始类型存储
+		mtTag.Storage = storageText
+		cipherValue, err = mt.encryptValue(ctx, mtTag, primaryKey, fieldValue)
+		if err != nil {
+			return
+		}
+		return mt.setCipherTxt(db, field, valueOf, cipherValue.(string))
+	case decrypt:
+		var value string
+		value, err = marshalFieldValue(reflect.ValueOf(fieldValue))
+		if err != nil {
+			err = mt.newError(err.Error())
+			return
+		}
+		var rv reflect.Value
+		rv, err = mt.decryptValue(ctx, mtTag, primaryKey, value, field.FieldType)
+		if err != nil {
+			return
 		}
+		newValue = rv.Interface()
+	}
+	// Set value to field
+	err = field.Set(ctx, valueOf, newValue)
+	if err != nil {
+		err = mt.newError("对结构体赋值异常：" + err.Error())
+		return
 	}
+	return
 }
 
-// analyzeDBModel
+// setCipherTxt
 /**
- *  @Description: 解析结构体数据
+ *  @Description: 按字段原始类型（string、[]byte）存放密文，语句执行后还原明文
  *  @receiver mt
  *  @param db
+ *  @param field
+ *  @param valueOf
+ *  @param cipherTxt
+ *  @return err
  */
-func (mt *MultiTenancy) analyzeDBModel(db *gorm.DB) {
-	if db.Error != nil {
+func (mt *MultiTenancy) setCipherTxt(db *gorm.DB, field *schema.Field, valueOf reflect.Value, cipherTxt string) (err error) {
+	ctx := db.Statement.Context
+	rv, err := unmarshalFieldValue(cipherTxt, field.FieldType)
+	if err != nil {
+		err = mt.newError(err.Error())
+		return
+	}
+	// 语句执行后还原明文，避免修改调用方的结构体
+	target := field.ReflectValueOf(ctx, valueOf)
+	original := reflect.New(target.Type()).Elem()
+	original.Set(target)
+	mt.addRestore(db, func() { target.Set(original) })
+	err = field.Set(ctx, valueOf, rv.Interface())
+	if err != nil {
+		err = mt.newError("对结构体赋值异常：" + err.Error())
 		return
 	}
+	return
+}
 
-	// 获取结构体 reflect.Type
-	//typeOf := db.Statement.Schema.ModelType
-	if db.Statement.Schema != nil {
-		for _, field := range db.Statement.Schema.Fields {
-			mtTag := MultiTenancyTag{
-				DBName:    field.DBName,
-				FieldName: field.Name,
-				FieldType: field.FieldType,
+// batchEncryptReflectValue
+/**
+ *  @Description: 批量创建时按字段合并加密结构体切片中直接存放密文的字段
+ *  @receiver mt
+ *  @param db
+ *  @param sch
+ *  @param rv 结构体切片
+ *  @return err
+ */
+func (mt *MultiTenancy) batchEncryptReflectValue(db *gorm.DB, sch *schema.Schema, rv reflect.Value) (err error) {
+	ctx := db.Statement.Context
+	tags := mt.analyzeSchema(sch)
+	for _, field := range sch.Fields {
+		if !tags.needEncrypt(field.DBName) || field.Serializer != nil {
+			// 使用加密序列化器的字段在写入时自行加密
+			continue
+		}
+		if !canHoldCipherTxt(field.FieldType) {
+			err = mt.newError(field.Name + "字段无法直接存放密文，请使用 serializer:" + EncryptSerializerName)
+			return
+		}
+		var elems []reflect.Value
+		var primaryKeys, plaintexts []string
+		for i := 0; i < rv.Len(); i++ {
+			elem := reflect.Indirect(rv.Index(i))
+			fieldValue, isZero := field.ValueOf(ctx, elem)
+			if isZero {
+				continue
 			}
-			value, ok := field.Tag.Lookup(DefaultTagName)
-			if ok {
-				mtTag.tag = value
+			var data string
+			data, err = marshalFieldValue(reflect.ValueOf(fieldValue))
+			if err != nil {
+				err = mt.newError(err.Error())
+				return
 			}
-			// 解析MTTag
-			mt.analyzeMTTag(mtTag.tag, &mtTag)
-			mt.tagMap[mtTag.DBName] = mtTag
-			if mt.tagMap[mtTag.DBName].Encrypt {
-				mt.needEncryptDBFields[mtTag.DBName] = struct{}{}
-				mt.needEncryptFields[field.Name] = struct{}{}
+			elems = append(elems, elem)
+			primaryKeys = append(primaryKeys, primaryKeyOf(ctx, sch, elem))
+			plaintexts = append(plaintexts, data)
+		}
+		if len(plaintexts) == 0 {
+			continue
+		}
+		var cipherTxts []string
+		cipherTxts, err = mt.encryptTexts(ctx, tags.tagMap[field.DBName], primaryKeys, plaintexts)
+		if err != nil {
+			return
+		}
+		for i, elem := range elems {
+			err = mt.setCipherTxt(db, field, elem, cipherTxts[i])
+			if err != nil {
+				return
 			}
 		}
 	}
 	return
 }
 
-func (mt *MultiTenancy) getReflectElem(i interface{}) (reflect.Type, reflect.Value) {
-	destType := reflect.TypeOf(i)
-	destValue := reflect.ValueOf(i)
-	for {
-		if destType.Elem().Kind() == reflect.Ptr {
-			destType = destType.Elem()
-			destValue = destValue.Elem()
-		} else if destType.Elem().Kind() == reflect.Struct {
-			break
-		} else {
-			return nil, reflect.ValueOf(nil)
-		}
-	}
-	return destType.Elem(), destValue.Elem()
+// encryptValue
+/**
+ *  @Description: 序列化并加密字段值
+ *  @receiver mt
+ *  @param ctx
+ *  @param mtTag
+ *  @param primaryKey 所在行的主键，未知时为空
+ *  @param value
+ *  @return cipherValue 根据存储方式返回 string 或 []byte
+ *  @return err
+ */
+func (mt *MultiTenancy) encryptValue(ctx context.Context, mtTag MultiTenancyTag, primaryKey string, value interface{}) (cipherValue interface{}, err error) {
+	data, err := marshalFieldValue(reflect.ValueOf(value))
+	if err != nil {
+		err = mt.newError(err.Error())
+		return
+	}
+	cipherTxts, err := mt.encryptTexts(ctx, mtTag, []string{primaryKey}, []string{data})
+	if err != nil {
+		return
+	}
+	cipherTxt := cipherTxts[0]
+	if mtTag.Storage == storageBinary {
+		cipherValue = []byte(cipherTxt)
+		return
+	}
+	cipherValue = cipherTxt
+	return
 }
 
-func (mt *MultiTenancy) setEncryptData(field *schema.Field, ctx context.Context, valueOf reflect.Value, flag int) (err error) {
-	// 获取值
-	fieldValue, isZero := field.ValueOf(ctx, valueOf)
-	if isZero {
+// decryptValue
+/**
+ *  @Description: 解密数据库中的值并还原为字段原始类型
+ *  @receiver mt
+ *  @param ctx
+ *  @param mtTag
+ *  @param primaryKey 所在行的主键，未知时为空
+ *  @param dbValue
+ *  @param typ 字段类型
+ *  @return fieldValue
+ *  @return err
+ */
+func (mt *MultiTenancy) decryptValue(ctx context.Context, mtTag MultiTenancyTag, primaryKey string, dbValue interface{}, typ reflect.Type) (fieldValue reflect.Value, err error) {
+	if mt.cipher == nil {
+		err = mt.newError("未设置解密方法")
 		return
 	}
-	value, ok := fieldValue.(string)
-	if !ok {
-		err = mt.newError("断言失败，仅支持string类型字段分库")
+	cipherTxt, err := cipherTxtOf(dbValue)
+	if err != nil {
+		err = mt.newError(err.Error())
 		return
 	}
-	cipherTxt := value
-	switch flag {
-	case encrypt:
-		if mt.encrypt == nil {
-			err = mt.newError("未设置加密方法")
+	if cipherTxt == "" {
+		// 空值无需解密
+		fieldValue = reflect.Zero(typ)
+		return
+	}
+	data, isCipherTxt := cipherTxt, true
+	if mt.tolerantRead {
+		// 兼容尚未加密的存量明文：仅按密文格式区分，密文格式的值解密失败时仍返回错误，避免将密文当作明文返回
+		isCipherTxt, err = mt.isCipherTxtFormat(cipherTxt)
+		if err != nil {
 			return
 		}
-		cipherTxt, err = mt.encrypt(value)
-		if err != nil {
-			err = mt.newError("加密异常：" + err.Error())
+		// 旧版本的密文不按明文返回，解密时返回错误
+		isCipherTxt = isCipherTxt || mt.isLegacyCipherTxt(cipherTxt)
+	}
+	if isCipherTxt {
+		if err = mt.checkPrimaryKey(mtTag, primaryKey); err != nil {
 			return
 		}
-	case decrypt:
-		if mt.decrypt == nil {
-			err = mt.newError("未设置解密方法")
+		data, err = mt.cipher.Decrypt(ctx, fieldInfo(ctx, mtTag, primaryKey), cipherTxt)
+		var tamperErr *TamperError
+		if errors.As(err, &tamperErr) {
+			// 返回原始错误便于调用方判断
+			err = tamperErr
 			return
 		}
-		cipherTxt, err = mt.decrypt(value)
 		if err != nil {
 			err = mt.newError("解密异常：" + err.Error())
 			return
 		}
 	}
-	// Set value to field
-	err = field.Set(ctx, valueOf, cipherTxt)
+	fieldValue, err = unmarshalFieldValue(data, typ)
 	if err != nil {
-		err = mt.newError("对结构体赋值异常：" + err.Error())
+		err = mt.newError("解密数据还原异常：" + err.Error())
+		return
+	}
+	return
+}
+
+// primaryKeyOf
+/**
+ *  @Description: 获取结构体的主键，未赋值时为空
+ *  @param ctx
+ *  @param sch
+ *  @param rv
+ *  @return primaryKey
+ */
+func primaryKeyOf(ctx context.Context, sch *schema.Schema, rv reflect.Value) (primaryKey string) {
+	if sch == nil || sch.PrioritizedPrimaryField == nil {
+		return
+	}
+	rv = reflect.Indirect(rv)
+	if rv.Kind() != reflect.Struct || rv.Type() != sch.ModelType {
+		return
+	}
+	value, isZero := sch.PrioritizedPrimaryField.ValueOf(ctx, rv)
+	if isZero {
+		return
+	}
+	primaryKey, _ = marshalFieldValue(reflect.ValueOf(value))
+	return
+}
+
+// rowPrimaryKey
+/**
+ *  @Description: 获取绑定主键的字段所在行的主键：结构体的主键，查询结果为其他结构体时按模型的主键字段名匹配，
+ *  更新时结构体中未赋值的主键使用语句操作的单行主键
+ *  @receiver mt
+ *  @param db
+ *  @param mtTag
+ *  @param field
+ *  @param rv
+ *  @return primaryKey 未绑定主键或未知时为空
+ */
+func (mt *MultiTenancy) rowPrimaryKey(db *gorm.DB, mtTag MultiTenancyTag, field *schema.Field, rv reflect.Value) (primaryKey string) {
+	if !mtTag.Bind {
 		return
 	}
+	ctx := db.Statement.Context
+	primaryKey = primaryKeyOf(ctx, field.Schema, rv)
+	sch := db.Statement.Schema
+	if primaryKey == "" && sch != nil && sch.PrioritizedPrimaryField != nil && field.Schema != nil && field.Schema != sch {
+		rv = reflect.Indirect(rv)
+		if pkField := field.Schema.LookUpField(sch.PrioritizedPrimaryField.DBName); pkField != nil && rv.Type() == field.Schema.ModelType {
+			if value, isZero := pkField.ValueOf(ctx, rv); !isZero {
+				primaryKey, _ = marshalFieldValue(reflect.ValueOf(value))
+			}
+		}
+	}
+	if primaryKey == "" {
+		primaryKey = primaryKeyFromContext(ctx)
+	}
+	return
+}
+
+// statementPrimaryKey
+/**
+ *  @Description: 获取语句操作的单行主键：Model 的主键或 WHERE 子句中的主键条件
+ *  @receiver mt
+ *  @param db
+ *  @return primaryKey 未指定或指定了多个主键时为空
+ */
+func (mt *MultiTenancy) statementPrimaryKey(db *gorm.DB) (primaryKey string) {
+	var exprs []clause.Expression
+	if where, ok := db.Statement.Clauses["WHERE"].Expression.(clause.Where); ok {
+		exprs = where.Exprs
+	}
+	for _, value := range mt.primaryKeyValues(db, exprs) {
+		key, err := marshalFieldValue(reflect.ValueOf(value))
+		if err != nil || key == "" || (primaryKey != "" && key != primaryKey) {
+			return ""
+		}
+		primaryKey = key
+	}
 	return
 }
 
@@ -152,134 +374,86 @@ func (mt *MultiTenancy) encryptCommonCallback(db *gorm.DB) {
 		return
 	}
 	if db.Statement.Schema != nil {
-		for _, field := range db.Statement.Schema.Fields {
+		// 关联数据由 GORM 单独执行 Create，在其自身的回调中加密
+		rv := reflect.Indirect(db.Statement.ReflectValue)
+		if _, ok := mt.cipher.(BatchFieldCipher); ok && rv.Kind() == reflect.Slice {
+			db.Error = mt.batchEncryptReflectValue(db, db.Statement.Schema, rv)
+			return
+		}
+		db.Error = mt.cryptReflectValue(db, db.Statement.Schema, db.Statement.ReflectValue, encrypt)
+	}
+}
+
+// cryptReflectValue
+/**
+ *  @Description: 对结构体（或结构体切片）中需要加密的字段进行加解密
+ *  @receiver mt
+ *  @param db
+ *  @param sch
+ *  @param rv
+ *  @param flag
+ *  @return err
+ */
+func (mt *MultiTenancy) cryptReflectValue(db *gorm.DB, sch *schema.Schema, rv reflect.Value, flag int) (err error) {
+	rv = reflect.Indirect(rv)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			err = mt.cryptReflectValue(db, sch, rv.Index(i), flag)
+			if err != nil {
+				return
+			}
+		}
+	case reflect.Struct:
+		// 对Tag进行解析
+		tags := mt.analyzeSchema(sch)
+		for _, field := range sch.Fields {
 			// 判断是否需要加密
-			mtTag, ok := mt.tagMap[field.DBName]
-			if !ok || !mtTag.Encrypt {
+			if !tags.needEncrypt(field.DBName) {
 				// 未查询到该字段 或 不需要加密
 				continue
 			}
-			switch db.Statement.ReflectValue.Kind() {
-			case reflect.Slice, reflect.Array:
-				for i := 0; i < db.Statement.ReflectValue.Len(); i++ {
-					db.Error = mt.setEncryptData(field, db.Statement.Context, db.Statement.ReflectValue.Index(i), encrypt)
-					if db.Error != nil {
-						return
-					}
-				}
+			err = mt.setEncryptData(db, tags.tagMap[field.DBName], field, rv, flag)
+			if err != nil {
 				return
-			case reflect.Struct:
-				db.Error = mt.setEncryptData(field, db.Statement.Context, db.Statement.ReflectValue, encrypt)
-				if db.Error != nil {
-					return
-				}
 			}
 		}
 	}
+	return
 }
 
-// encryptBySql
+// decryptJoins
 /**
- *  @Description: 加密Sql
+ *  @Description: 解密通过 Joins 查询的关联结构体（Preload 的关联数据在其自身的查询回调中解密）
  *  @receiver mt
  *  @param db
+ *  @param rv
+ *  @return err
  */
-func (mt *MultiTenancy) encryptBySql(db *gorm.DB) {
-	if db.Error != nil {
-		return
-	}
-	// 若无需要加密的字段，则不需要解析SQL
-	if mt.needEncryptDBFields == nil || len(mt.needEncryptDBFields) == 0 {
-		return
-	}
-	whereClauses, ok := db.Statement.Clauses["WHERE"].Expression.(clause.Where)
-	if !ok {
-		db.Error = mt.newError("未检测到 WHERE 子句")
-		return
-	}
-	exprs := whereClauses.Exprs
-	for i, expr := range exprs {
-		switch exprType := expr.(type) {
-		case clause.Eq:
-			_, ok = mt.needEncryptDBFields[exprType.Column.(string)]
-			if !ok {
-				_, ok = mt.needEncryptDBFields[exprType.Column.(clause.Column).Name]
-			}
-			if ok {
-				expri := clause.Eq{
-					Column: exprType.Column,
-				}
-				expri.Value, db.Error = mt.encrypt(utils.ToString(exprType.Value))
-				if db.Error != nil {
-					return
-				}
-				exprs[i] = expri
+func (mt *MultiTenancy) decryptJoins(db *gorm.DB, rv reflect.Value) (err error) {
+	rv = reflect.Indirect(rv)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			err = mt.decryptJoins(db, rv.Index(i))
+			if err != nil {
+				return
 			}
-		case clause.IN:
-		case clause.Expr:
-			if exprType.Vars == nil {
+		}
+	case reflect.Struct:
+		if rv.Type() != db.Statement.Schema.ModelType {
+			return
+		}
+		joins, _ := db.Statement.Settings.Load(joinsSettingKey)
+		joinNames, _ := joins.([]string)
+		for _, name := range joinNames {
+			rel, ok := db.Statement.Schema.Relationships.Relations[name]
+			if !ok {
 				continue
 			}
-			sql := exprType.SQL
-			for fields, _ := range mt.needEncryptDBFields {
-				if strings.Contains(sql, fields+" ") {
-					switch {
-					case strings.Contains(sql, fields+" = ?"):
-						// 获取sql片段
-						subSql := fields + " = ?"
-						// 获取该片段出现的位置
-						index := strings.Index(sql, subSql)
-						// 或者该字段对应的?的索引
-						count := strings.Count(sql[:index+len(subSql)], "?")
-						value := utils.ToString(exprType.Vars[count-1])
-						exprType.Vars[count-1], db.Error = mt.encrypt(value)
-					case strings.Contains(sql, fields+" != ?"):
-						// 获取sql片段
-						subSql := fields + " != ?"
-						// 获取该片段出现的位置
-						index := strings.Index(sql, subSql)
-						// 或者该字段对应的?的索引
-						count := strings.Count(sql[:index+len(subSql)], "?")
-						value := utils.ToString(exprType.Vars[count-1])
-						exprType.Vars[count-1], db.Error = mt.encrypt(value)
-					case strings.Contains(sql, fields+" in ?"):
-						// 获取sql片段
-						subSql := fields + " in ?"
-						// 获取该片段出现的位置
-						index := strings.Index(sql, subSql)
-						// 或者该字段对应的?的索引
-						count := strings.Count(sql[:index+len(subSql)], "?")
-						values, ok := exprType.Vars[count-1].([]string)
-						if ok {
-							for j, value := range values {
-								values[j], db.Error = mt.encrypt(value)
-								if db.Error != nil {
-									return
-								}
-							}
-						}
-						exprType.Vars[count-1] = values
-					case strings.Contains(sql, fields+" not in ?"):
-						// 获取sql片段
-						subSql := fields + " not in ?"
-						// 获取该片段出现的位置
-						index := strings.Index(sql, subSql)
-						// 或者该字段对应的?的索引
-						count := strings.Count(sql[:index+len(subSql)], "?")
-						values, ok := exprType.Vars[count-1].([]string)
-						if ok {
-							for j, value := range values {
-								values[j], db.Error = mt.encrypt(value)
-								if db.Error != nil {
-									return
-								}
-							}
-						}
-						exprType.Vars[count-1] = values
-					default:
-						db.Error = mt.newError(fields + "字段已经开启加密，仅支持精确匹配查询")
-					}
-				}
+			err = mt.cryptReflectValue(db, rel.FieldSchema, rel.Field.ReflectValueOf(db.Statement.Context, rv), decrypt)
+			if err != nil {
+				return
 			}
 		}
 	}
@@ -290,14 +464,39 @@ func (mt *MultiTenancy) encryptCreateBeforeCallback(db *gorm.DB) {
 	if db.Error != nil {
 		return
 	}
+	// 校验模型的 mt Tag
+	if !mt.checkModelTags(db) {
+		return
+	}
+	// 计算哈希字段的哈希值
+	mt.hashCreateBeforeCallback(db)
 	// 若未开启加密保存，则不执行后续操作
-	if !mt.encryptedSave {
+	if db.Error != nil || !mt.encryptedSave {
 		return
 	}
-	// 对Tag进行解析
-	mt.analyzeDBModel(db)
+	// 记录需要脱敏的值
+	mt.withRedact(db)
+	// 按明文计算查询索引
+	if db.Statement.Schema != nil {
+		db.Error = mt.indexReflectValue(db, db.Statement.Schema, db.Statement.Schema.Table, db.Statement.ReflectValue)
+	}
 	// 加密结构体数据
 	mt.encryptCommonCallback(db)
+	if db.Error != nil {
+		return
+	}
+	// 加密 Map 创建的数据
+	if db.Statement.Schema != nil {
+		tags := mt.analyzeSchema(db.Statement.Schema)
+		for _, row := range createMaps(db.Statement.Dest) {
+			db.Error = mt.encryptMap(db, tags, row, mapPrimaryKey(db.Statement.Schema, row))
+			if db.Error != nil {
+				return
+			}
+		}
+	}
+	// 加密 ON CONFLICT 中的更新值
+	mt.encryptBySql(db)
 }
 
 func (mt *MultiTenancy) decryptQueryAfterCallback(db *gorm.DB) {
@@ -308,54 +507,78 @@ func (mt *MultiTenancy) decryptQueryAfterCallback(db *gorm.DB) {
 	if !mt.encryptedSave {
 		return
 	}
-	// 对Tag进行解析
-	mt.analyzeDBModel(db)
+	if db.Statement.Schema != nil {
+		db.Error = mt.decryptDest(db, db.Statement.Schema, db.Statement.ReflectValue, mt.selectColumns(db))
+		if db.Error != nil {
+			return
+		}
+		db.Error = mt.decryptJoins(db, db.Statement.ReflectValue)
+		if db.Error != nil {
+			return
+		}
+		// 清除自动查询的主键
+		if _, ok := db.Statement.Settings.LoadAndDelete(selectPrimaryKeySettingKey); ok {
+			mt.clearPrimaryKey(db, db.Statement.ReflectValue)
+		}
+	}
+}
+
+func (mt *MultiTenancy) encryptQueryBeforeCallback(db *gorm.DB) {
 	if db.Error != nil {
 		return
 	}
-	if db.Statement.Schema != nil {
-		for _, field := range db.Statement.Schema.Fields {
-			// 判断是否需要解密
-			mtTag, ok := mt.tagMap[field.DBName]
-			if !ok || !mtTag.Encrypt {
-				// 未查询到该字段 或 不需要加密
-				continue
-			}
-			switch db.Statement.ReflectValue.Kind() {
-			case reflect.Slice, reflect.Array:
-				for i := 0; i < db.Statement.ReflectValue.Len(); i++ {
-					db.Error = mt.setEncryptData(field, db.Statement.Context, db.Statement.ReflectValue.Index(i), decrypt)
-					if db.Error != nil {
-						return
-					}
-				}
-			case reflect.Struct:
-				// 获取值
-				db.Error = mt.setEncryptData(field, db.Statement.Context, db.Statement.ReflectValue, decrypt)
-				if db.Error != nil {
-					return
-				}
-			default:
-			}
+	// 校验模型的 mt Tag
+	if !mt.checkModelTags(db) {
+		return
+	}
+	// 若未开启加密保存，则不执行后续操作
+	if !mt.encryptedSave {
+		return
+	}
+	// 记录需要脱敏的值
+	mt.withRedact(db)
+	// 记录 Joins 的关联，构建 SQL 后 Statement.Joins 会被清空
+	if len(db.Statement.Joins) > 0 {
+		joinNames := make([]string, 0, len(db.Statement.Joins))
+		for _, join := range db.Statement.Joins {
+			joinNames = append(joinNames, join.Name)
 		}
+		db.Statement.Settings.Store(joinsSettingKey, joinNames)
 	}
+	// 加密sql
+	mt.encryptBySql(db)
+	// 查询绑定主键的字段时查询主键
+	mt.selectPrimaryKey(db)
 }
 
-func (mt *MultiTenancy) encryptQueryBeforeCallback(db *gorm.DB) {
+func (mt *MultiTenancy) encryptDeleteBeforeCallback(db *gorm.DB) {
 	if db.Error != nil {
 		return
 	}
+	// 校验模型的 mt Tag
+	if !mt.checkModelTags(db) {
+		return
+	}
 	// 若未开启加密保存，则不执行后续操作
 	if !mt.encryptedSave {
 		return
 	}
-	// 对Tag进行解析
-	mt.analyzeDBModel(db)
+	// 记录需要脱敏的值
+	mt.withRedact(db)
 	// 加密sql
 	mt.encryptBySql(db)
 }
 
 func (mt *MultiTenancy) encryptUpdateBeforeCallback(db *gorm.DB) {
+	if db.Error != nil {
+		return
+	}
+	// 校验模型的 mt Tag
+	if !mt.checkModelTags(db) {
+		return
+	}
+	// 计算哈希字段的哈希值
+	mt.hashUpdateBeforeCallback(db)
 	if db.Error != nil {
 		return
 	}
@@ -363,45 +586,237 @@ func (mt *MultiTenancy) encryptUpdateBeforeCallback(db *gorm.DB) {
 	if !mt.encryptedSave {
 		return
 	}
-	// 对Tag进行解析
-	mt.analyzeDBModel(db)
-
+	// 记录需要脱敏的值
+	mt.withRedact(db)
 	if db.Statement.Schema == nil {
 		return
 	}
+	// 对Tag进行解析
+	tags := mt.analyzeSchema(db.Statement.Schema)
+	if tags.bind {
+		// 绑定主键的字段按语句操作的单行主键加密（Map、SET 及结构体中未赋值主键时）
+		db.Statement.Context = withPrimaryKey(db.Statement.Context, mt.statementPrimaryKey(db))
+	}
+	// 加密更新条件
+	mt.encryptBySql(db)
+	if db.Error != nil {
+		return
+	}
 	if updateInfo, ok := db.Statement.Dest.(map[string]interface{}); ok {
-		for updateColumn := range updateInfo {
-			_, ok = mt.needEncryptDBFields[updateColumn]
-			if !ok {
-				// 不需要加密的字段提前跳出循环
-				continue
-			}
-			updateV := updateInfo[updateColumn]
-			var newValue string
-			newValue, db.Error = mt.encrypt(utils.ToString(updateV))
-			if db.Error != nil {
-				return
-			}
-			updateInfo[updateColumn] = newValue
+		// 绑定主键的字段使用语句操作的单行主键
+		db.Error = mt.encryptMap(db, tags, updateInfo, primaryKeyFromContext(db.Statement.Context))
+		return
+	}
+	updatingValue, updatingSchema, isModel := mt.updatingStruct(db)
+	if db.Error != nil || updatingSchema == nil {
+		return
+	}
+	// 按明文计算查询索引
+	db.Error = mt.indexReflectValue(db, updatingSchema, db.Statement.Schema.Table, updatingValue)
+	if db.Error != nil {
+		return
+	}
+	tags = mt.analyzeSchema(updatingSchema)
+	for _, field := range updatingSchema.Fields {
+		if !tags.needEncrypt(field.DBName) || field.Serializer != nil {
+			// 使用加密序列化器的字段在写入时自行加密
+			continue
+		}
+		value, isZero := field.ValueOf(db.Statement.Context, updatingValue)
+		if isZero {
+			continue
+		}
+		mtTag := tags.tagMap[field.DBName]
+		// 使用其他结构体更新时，按模型的表名加密
+		mtTag.Table = db.Statement.Schema.Table
+		db.Error = mt.setEncryptData(db, mtTag, field, updatingValue, encrypt)
+		if db.Error != nil {
+			return
+		}
+		if !isModel {
+			mt.restoreModelField(db, field.DBName, value)
 		}
+	}
+}
+
+// encryptMap
+/**
+ *  @Description: 加密 Map 中需要加密的字段（Create、Updates），键可为字段名或数据库字段名，语句执行后还原
+ *  @receiver mt
+ *  @param db
+ *  @param tags
+ *  @param values
+ *  @param primaryKey 所在行的主键，用于绑定主键的字段
+ *  @return err
+ */
+func (mt *MultiTenancy) encryptMap(db *gorm.DB, tags *modelTags, values map[string]interface{}, primaryKey string) (err error) {
+	// 按明文计算查询索引
+	if err = mt.indexMap(db, tags, values); err != nil {
 		return
 	}
-	typeOf, valueOf := mt.getReflectElem(db.Statement.Dest)
+	for key, value := range values {
+		column := mapColumn(db.Statement.Schema, key)
+		if !tags.needEncrypt(column) {
+			continue
+		}
+		var cipherValue interface{}
+		cipherValue, err = mt.encryptRowVar(db, tags, column, primaryKey, value)
+		if err != nil {
+			return
+		}
+		values[key] = cipherValue
+		mapKey, plainValue := key, value
+		mt.addRestore(db, func() { values[mapKey] = plainValue })
+		mt.restoreModelField(db, column, value)
+	}
+	return
+}
 
-	if typeOf != nil {
-		for i := 0; i < typeOf.NumField(); i++ {
-			field := typeOf.Field(i)
-			_, ok := mt.needEncryptFields[field.Name]
-			if !ok {
-				continue
-			}
-			val := valueOf.Field(i).String()
-			if len(val) == 0 {
-				continue
-			}
-			var cipherTxt string
-			cipherTxt, db.Error = mt.encrypt(utils.ToString(val))
-			valueOf.Field(i).SetString(cipherTxt)
+// mapColumn
+/**
+ *  @Description: 获取 Map 的键对应的数据库字段名，键可为字段名或数据库字段名
+ *  @param sch
+ *  @param key
+ *  @return column 非模型字段时为原键
+ */
+func mapColumn(sch *schema.Schema, key string) (column string) {
+	if field := sch.LookUpField(key); field != nil && field.DBName != "" {
+		return field.DBName
+	}
+	return key
+}
+
+// mapPrimaryKey
+/**
+ *  @Description: 获取 Map 中的主键
+ *  @param sch
+ *  @param values
+ *  @return primaryKey 未赋值时为空
+ */
+func mapPrimaryKey(sch *schema.Schema, values map[string]interface{}) (primaryKey string) {
+	if sch.PrioritizedPrimaryField == nil {
+		return
+	}
+	for key, value := range values {
+		if mapColumn(sch, key) == sch.PrioritizedPrimaryField.DBName {
+			primaryKey, _ = marshalFieldValue(reflect.ValueOf(value))
+			return
+		}
+	}
+	return
+}
+
+// createMaps
+/**
+ *  @Description: 获取通过 Map 创建（Create(map)、Create([]map)）的各行
+ *  @param dest
+ *  @return rows 非 Map 创建时为空
+ */
+func createMaps(dest interface{}) (rows []map[string]interface{}) {
+	switch value := dest.(type) {
+	case map[string]interface{}:
+		rows = []map[string]interface{}{value}
+	case *map[string]interface{}:
+		rows = []map[string]interface{}{*value}
+	case []map[string]interface{}:
+		rows = value
+	case *[]map[string]interface{}:
+		rows = *value
+	}
+	return
+}
+
+// updatingStruct
+/**
+ *  @Description: 获取更新的结构体，非指针结构体复制后替换为指针以便赋值
+ *  @receiver mt
+ *  @param db
+ *  @return updatingValue
+ *  @return updatingSchema 非结构体更新时为 nil
+ *  @return isModel 更新的结构体是否为 Model
+ */
+func (mt *MultiTenancy) updatingStruct(db *gorm.DB) (updatingValue reflect.Value, updatingSchema *schema.Schema, isModel bool) {
+	updatingValue = reflect.ValueOf(db.Statement.Dest)
+	for updatingValue.Kind() == reflect.Ptr {
+		updatingValue = updatingValue.Elem()
+	}
+	if updatingValue.Kind() != reflect.Struct {
+		return
+	}
+	updatingSchema = db.Statement.Schema
+	if updatingValue.Type() != updatingSchema.ModelType {
+		// 使用其他结构体更新
+		updatingStmt := &gorm.Statement{DB: db}
+		db.Error = updatingStmt.Parse(db.Statement.Dest)
+		if db.Error != nil {
+			return
 		}
+		updatingSchema = updatingStmt.Schema
+	}
+	isModel = db.Statement.Dest == db.Statement.Model
+	if !updatingValue.CanAddr() {
+		// 非指针结构体无法赋值，复制后替换
+		dest := reflect.New(updatingValue.Type())
+		dest.Elem().Set(updatingValue)
+		db.Statement.Dest = dest.Interface()
+		updatingValue = dest.Elem()
+	}
+	return
+}
+
+// restoreModelField
+/**
+ *  @Description: GORM 会将更新的值同步到 Model，语句执行后将其还原为明文
+ *  @receiver mt
+ *  @param db
+ *  @param dbName
+ *  @param value
+ */
+func (mt *MultiTenancy) restoreModelField(db *gorm.DB, dbName string, value interface{}) {
+	// 此时 ReflectValue 尚未切换为 Model
+	rv := reflect.ValueOf(db.Statement.Model)
+	for rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct || !rv.CanAddr() {
+		return
+	}
+	field := db.Statement.Schema.LookUpField(dbName)
+	if field == nil {
+		return
+	}
+	ctx := db.Statement.Context
+	mt.addRestore(db, func() { _ = field.Set(ctx, rv, value) })
+}
+
+// addRestore
+/**
+ *  @Description: 记录还原明文的方法，语句执行后调用
+ *  @receiver mt
+ *  @param db
+ *  @param restore
+ */
+func (mt *MultiTenancy) addRestore(db *gorm.DB, restore func()) {
+	var restores []func()
+	if v, ok := db.Statement.Settings.Load(restoreSettingKey); ok {
+		restores = v.([]func())
+	}
+	db.Statement.Settings.Store(restoreSettingKey, append(restores, restore))
+}
+
+// restoreAfterCallback
+/**
+ *  @Description: 语句执行后还原调用方对象中的明文
+ *  @receiver mt
+ *  @param db
+ */
+func (mt *MultiTenancy) restoreAfterCallback(db *gorm.DB) {
+	v, ok := db.Statement.Settings.LoadAndDelete(restoreSettingKey)
+	if !ok {
+		return
+	}
+	restores := v.([]func())
+	for i := len(restores) - 1; i >= 0; i-- {
+		restores[i]()
 	}
 }
@@ -0,0 +1,491 @@
+/**
+ * @Time    :2023/7/19 10:40
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package plugin
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"gorm.io/gorm"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// 数据密钥缓存默认有效期
+	defaultDataKeyTTL = 10 * time.Minute
+	// 数据密钥长度（AES-256）
+	dataKeySize = 32
+	// 信封加密密文格式版本，V1 不含附加认证数据，读取时返回错误，仅由存量数据加密解密后重新加密
+	envelopeFormatV1 byte = 1
+	// V2 的附加认证数据包含租户ID、表名、字段名，绑定主键的字段还包含所在行的主键
+	envelopeFormatV2 byte = 2
+	// V1 密文头长度：格式版本（1字节）+ 数据密钥版本（4字节）
+	envelopeHeaderSizeV1 = 5
+	// V2 密文头长度：格式版本（1字节）+ 绑定标识（1字节）+ 数据密钥版本（4字节）
+	envelopeHeaderSizeV2 = 6
+)
+
+// DataKey 租户数据密钥，由主密钥加密后保存在主库中
+type DataKey struct {
+	ID          uint64    `json:"id"          gorm:"column:id;primaryKey;autoIncrement"`
+	TenantId    string    `json:"tenantId"    gorm:"column:tenant_id;type:varchar(64);uniqueIndex:uk_mt_data_key_version"`
+	Version     uint32    `json:"version"     gorm:"column:version;uniqueIndex:uk_mt_data_key_version"`
+	MasterKeyId string    `json:"masterKeyId" gorm:"column:master_key_id;type:varchar(64)"`
+	CipherKey   string    `json:"-"           gorm:"column:cipher_key;type:varchar(255)"`
+	BindAAD     bool      `json:"bindAAD"     gorm:"column:bind_aad"` // 包装时附加认证数据是否包含租户ID及版本，旧版本的数据密钥为 false，可通过 RewrapDataKeys 重新包装
+	CreateTime  time.Time `json:"createTime"  gorm:"column:create_time"`
+}
+
+func (DataKey) TableName() string {
+	return "mt_data_key"
+}
+
+// cachedDataKey 已解密的数据密钥
+type cachedDataKey struct {
+	version  uint32
+	key      []byte
+	expireAt time.Time
+}
+
+// Envelope 信封加密，各租户使用独立的数据密钥加密字段，数据密钥由 KMS 主密钥加密后保存
+type Envelope struct {
+	db          *gorm.DB
+	kms         KMS
+	masterKeyId string
+	ttl         time.Duration
+	mu          sync.Mutex
+	current     map[string]cachedDataKey // 租户ID -> 当前数据密钥
+	keys        map[string]cachedDataKey // 租户ID:版本 -> 数据密钥
+}
+
+// NewEnvelope
+/**
+ *  @Description: 创建信封加密，并在主库中创建数据密钥表
+ *  @param db 主库
+ *  @param kms 密钥管理服务
+ *  @param masterKeyId 加密新数据密钥使用的主密钥ID
+ *  @param ttl 数据密钥缓存有效期，小于等于0时使用默认值
+ *  @return envelope
+ *  @return err
+ */
+func NewEnvelope(db *gorm.DB, kms KMS, masterKeyId string, ttl time.Duration) (envelope *Envelope, err error) {
+	if db == nil || kms == nil {
+		err = errors.New("【gorm:multi-tenancy】信封加密须指定主库及密钥管理服务")
+		return
+	}
+	if ttl <= 0 {
+		ttl = defaultDataKeyTTL
+	}
+	err = db.AutoMigrate(&DataKey{})
+	if err != nil {
+		return
+	}
+	envelope = &Envelope{
+		db:          db,
+		kms:         kms,
+		masterKeyId: masterKeyId,
+		ttl:         ttl,
+		current:     make(map[string]cachedDataKey),
+		keys:        make(map[string]cachedDataKey),
+	}
+	return
+}
+
+// Encrypt
+/**
+ *  @Description: 使用租户的当前数据密钥加密
+ *  @receiver e
+ *  @param ctx
+ *  @param field
+ *  @param data
+ *  @return cipherTxt
+ *  @return err
+ */
+func (e *Envelope) Encrypt(ctx context.Context, field FieldInfo, data string) (cipherTxt string, err error) {
+	if err = checkBinding(field); err != nil {
+		return
+	}
+	dataKey, err := e.currentDataKey(ctx, field.TenantID)
+	if err != nil {
+		return
+	}
+	header := make([]byte, envelopeHeaderSizeV2)
+	header[0] = envelopeFormatV2
+	header[1] = bindFlag(field)
+	binary.BigEndian.PutUint32(header[2:], dataKey.version)
+	sealed, err := seal(header[1], dataKey.key, []byte(data), fieldAAD(header, field))
+	if err != nil {
+		return
+	}
+	cipherTxt = base64.StdEncoding.EncodeToString(append(header, sealed...))
+	return
+}
+
+// Decrypt
+/**
+ *  @Description: 按密文中记录的数据密钥版本，使用租户的数据密钥解密
+ *  @receiver e
+ *  @param ctx
+ *  @param field
+ *  @param cipherTxt
+ *  @return data
+ *  @return err
+ */
+func (e *Envelope) Decrypt(ctx context.Context, field FieldInfo, cipherTxt string) (data string, err error) {
+	buf, err := base64.StdEncoding.DecodeString(cipherTxt)
+	if err != nil {
+		return
+	}
+	if len(buf) > 0 && buf[0] == envelopeFormatV1 {
+		// 不解密不含附加认证数据的 V1 密文，避免绕过租户、表、字段的校验
+		err = errors.New("V1 密文不含附加认证数据，请通过 EncryptExisting 或 mt-encrypt 重新加密")
+		return
+	}
+	if len(buf) <= envelopeHeaderSizeV2 || buf[0] != envelopeFormatV2 {
+		err = errors.New("密文格式异常")
+		return
+	}
+	header := buf[:envelopeHeaderSizeV2]
+	flag := header[1]
+	err = checkFlag(flag, field)
+	if err != nil {
+		return
+	}
+	aad := fieldAAD(header, field)
+	version := binary.BigEndian.Uint32(header[2:])
+	dataKey, err := e.dataKey(ctx, field.TenantID, version)
+	if err != nil {
+		return
+	}
+	plaintext, err := open(flag, dataKey.key, buf[len(header):], aad)
+	if err != nil {
+		err = &TamperError{Field: field, Err: err}
+		return
+	}
+	data = string(plaintext)
+	return
+}
+
+// IsCipherTxt
+/**
+ *  @Description: 实现 CipherTxtChecker，校验 Base64 编码、格式版本及长度
+ *  @receiver e
+ *  @param value
+ *  @return bool
+ */
+func (e *Envelope) IsCipherTxt(value string) bool {
+	buf, err := base64.StdEncoding.DecodeString(value)
+	return err == nil && len(buf) >= envelopeHeaderSizeV2+gcmSealedOverhead && buf[0] == envelopeFormatV2
+}
+
+// IsLegacyCipherTxt
+/**
+ *  @Description: 实现 LegacyCipher，值是否为 V1 密文格式
+ *  @receiver e
+ *  @param value
+ *  @return bool
+ */
+func (e *Envelope) IsLegacyCipherTxt(value string) bool {
+	buf, err := base64.StdEncoding.DecodeString(value)
+	return err == nil && len(buf) >= envelopeHeaderSizeV1+gcmSealedOverhead && buf[0] == envelopeFormatV1
+}
+
+// DecryptLegacy
+/**
+ *  @Description: 实现 LegacyCipher，解密 V1 密文，仅用于存量数据重新加密
+ *  @receiver e
+ *  @param ctx
+ *  @param field
+ *  @param cipherTxt
+ *  @return data
+ *  @return err
+ */
+func (e *Envelope) DecryptLegacy(ctx context.Context, field FieldInfo, cipherTxt string) (data string, err error) {
+	if !e.IsLegacyCipherTxt(cipherTxt) {
+		err = errors.New("密文格式异常")
+		return
+	}
+	// V1 密文不绑定所在行的主键
+	if err = checkFlag(0, field); err != nil {
+		return
+	}
+	buf, _ := base64.StdEncoding.DecodeString(cipherTxt)
+	version := binary.BigEndian.Uint32(buf[1:envelopeHeaderSizeV1])
+	dataKey, err := e.dataKey(ctx, field.TenantID, version)
+	if err != nil {
+		return
+	}
+	plaintext, err := openGCM(dataKey.key, buf[envelopeHeaderSizeV1:], nil)
+	if err != nil {
+		err = &TamperError{Field: field, Err: err}
+		return
+	}
+	data = string(plaintext)
+	return
+}
+
+// RotateDataKey
+/**
+ *  @Description: 为租户生成新版本的数据密钥，之后写入的数据使用新密钥加密，已有数据仍可使用旧密钥解密
+ *  @receiver e
+ *  @param ctx
+ *  @param tenantId
+ *  @return version 新数据密钥版本
+ *  @return err
+ */
+func (e *Envelope) RotateDataKey(ctx context.Context, tenantId string) (version uint32, err error) {
+	latest, err := e.latestDataKey(ctx, tenantId)
+	if err != nil {
+		return
+	}
+	if latest != nil {
+		version = latest.Version
+	}
+	dataKey, err := e.createDataKey(ctx, tenantId, version+1)
+	if err != nil {
+		return
+	}
+	e.mu.Lock()
+	e.current[tenantId] = dataKey
+	e.mu.Unlock()
+	version = dataKey.version
+	return
+}
+
+// currentDataKey
+/**
+ *  @Description: 获取租户当前版本的数据密钥，不存在时创建
+ *  @receiver e
+ *  @param ctx
+ *  @param tenantId
+ *  @return dataKey
+ *  @return err
+ */
+func (e *Envelope) currentDataKey(ctx context.Context, tenantId string) (dataKey cachedDataKey, err error) {
+	e.mu.Lock()
+	dataKey, ok := e.current[tenantId]
+	e.mu.Unlock()
+	if ok && time.Now().Before(dataKey.expireAt) {
+		return
+	}
+	latest, err := e.latestDataKey(ctx, tenantId)
+	if err != nil {
+		return
+	}
+	if latest == nil {
+		dataKey, err = e.createDataKey(ctx, tenantId, 1)
+		if err != nil {
+			// 其他实例可能已创建该租户的数据密钥
+			latest, _ = e.latestDataKey(ctx, tenantId)
+			if latest == nil {
+				return
+			}
+			err = nil
+		}
+	}
+	if latest != nil {
+		dataKey, err = e.unwrap(ctx, latest)
+		if err != nil {
+			return
+		}
+	}
+	e.mu.Lock()
+	e.current[tenantId] = dataKey
+	e.mu.Unlock()
+	return
+}
+
+// dataKey
+/**
+ *  @Description: 获取租户指定版本的数据密钥
+ *  @receiver e
+ *  @param ctx
+ *  @param tenantId
+ *  @param version
+ *  @return dataKey
+ *  @return err
+ */
+func (e *Envelope) dataKey(ctx context.Context, tenantId string, version uint32) (dataKey cachedDataKey, err error) {
+	cacheKey := tenantId + ":" + strconv.FormatUint(uint64(version), 10)
+	e.mu.Lock()
+	dataKey, ok := e.keys[cacheKey]
+	e.mu.Unlock()
+	if ok && time.Now().Before(dataKey.expireAt) {
+		return
+	}
+	var keys []DataKey
+	err = e.db.WithContext(ctx).Where("tenant_id = ? AND version = ?", tenantId, version).Limit(1).Find(&keys).Error
+	if err != nil {
+		return
+	}
+	if len(keys) == 0 {
+		err = errors.New("租户 " + tenantId + " 的数据密钥（版本" + strconv.FormatUint(uint64(version), 10) + "）不存在")
+		return
+	}
+	return e.unwrap(ctx, &keys[0])
+}
+
+// latestDataKey
+/**
+ *  @Description: 查询租户最新版本的数据密钥
+ *  @receiver e
+ *  @param ctx
+ *  @param tenantId
+ *  @return latest 不存在时为 nil
+ *  @return err
+ */
+func (e *Envelope) latestDataKey(ctx context.Context, tenantId string) (latest *DataKey, err error) {
+	var keys []DataKey
+	err = e.db.WithContext(ctx).Where("tenant_id = ?", tenantId).Order("version DESC").Limit(1).Find(&keys).Error
+	if err != nil || len(keys) == 0 {
+		return
+	}
+	latest = &keys[0]
+	return
+}
+
+// createDataKey
+/**
+ *  @Description: 生成数据密钥，使用主密钥加密后保存
+ *  @receiver e
+ *  @param ctx
+ *  @param tenantId
+ *  @param version
+ *  @return dataKey
+ *  @return err
+ */
+func (e *Envelope) createDataKey(ctx context.Context, tenantId string, version uint32) (dataKey cachedDataKey, err error) {
+	key := make([]byte, dataKeySize)
+	_, err = rand.Read(key)
+	if err != nil {
+		return
+	}
+	cipherKey, err := e.kms.WrapKey(ctx, e.masterKeyId, key, dataKeyAAD(tenantId, version))
+	if err != nil {
+		return
+	}
+	err = e.db.WithContext(ctx).Create(&DataKey{
+		TenantId:    tenantId,
+		Version:     version,
+		MasterKeyId: e.masterKeyId,
+		CipherKey:   base64.StdEncoding.EncodeToString(cipherKey),
+		BindAAD:     true,
+		CreateTime:  time.Now(),
+	}).Error
+	if err != nil {
+		return
+	}
+	dataKey = e.cache(tenantId, version, key)
+	return
+}
+
+// unwrap
+/**
+ *  @Description: 使用 KMS 解密数据密钥并缓存
+ *  @receiver e
+ *  @param ctx
+ *  @param row
+ *  @return dataKey
+ *  @return err
+ */
+func (e *Envelope) unwrap(ctx context.Context, row *DataKey) (dataKey cachedDataKey, err error) {
+	cipherKey, err := base64.StdEncoding.DecodeString(row.CipherKey)
+	if err != nil {
+		return
+	}
+	var aad []byte
+	if row.BindAAD {
+		aad = dataKeyAAD(row.TenantId, row.Version)
+	}
+	key, err := e.kms.UnwrapKey(ctx, row.MasterKeyId, cipherKey, aad)
+	if err != nil {
+		return
+	}
+	dataKey = e.cache(row.TenantId, row.Version, key)
+	return
+}
+
+// RewrapDataKeys
+/**
+ *  @Description: 重新包装未绑定租户ID及版本的旧数据密钥，使用各行原有的主密钥，数据密钥不变，已加密的数据无需重新加密；可重复执行
+ *  @receiver e
+ *  @param ctx
+ *  @return count 重新包装的数据密钥数量
+ *  @return err
+ */
+func (e *Envelope) RewrapDataKeys(ctx context.Context) (count int64, err error) {
+	var rows []DataKey
+	err = e.db.WithContext(ctx).Where("bind_aad = ?", false).Order("id").Find(&rows).Error
+	if err != nil {
+		return
+	}
+	for i := range rows {
+		row := &rows[i]
+		var cipherKey, key []byte
+		cipherKey, err = base64.StdEncoding.DecodeString(row.CipherKey)
+		if err != nil {
+			return
+		}
+		key, err = e.kms.UnwrapKey(ctx, row.MasterKeyId, cipherKey, nil)
+		if err != nil {
+			err = errors.New("租户 " + row.TenantId + " 的数据密钥（版本" + strconv.FormatUint(uint64(row.Version), 10) + "）解包异常：" + err.Error())
+			return
+		}
+		cipherKey, err = e.kms.WrapKey(ctx, row.MasterKeyId, key, dataKeyAAD(row.TenantId, row.Version))
+		if err != nil {
+			return
+		}
+		result := e.db.WithContext(ctx).Model(&DataKey{}).Where("id = ? AND bind_aad = ?", row.ID, false).
+			Updates(map[string]interface{}{"cipher_key": base64.StdEncoding.EncodeToString(cipherKey), "bind_aad": true})
+		if result.Error != nil {
+			err = result.Error
+			return
+		}
+		count += result.RowsAffected
+	}
+	return
+}
+
+// dataKeyAAD
+/**
+ *  @Description: 生成包装数据密钥的附加认证数据：租户ID（带长度前缀）|版本，数据密钥被复制到其他租户或版本时无法解包
+ *  @param tenantId
+ *  @param version
+ *  @return aad
+ */
+func dataKeyAAD(tenantId string, version uint32) (aad []byte) {
+	aad = make([]byte, 4, 8+len(tenantId))
+	binary.BigEndian.PutUint32(aad, uint32(len(tenantId)))
+	aad = append(aad, tenantId...)
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], version)
+	return append(aad, size[:]...)
+}
+
+// cache
+/**
+ *  @Description: 缓存已解密的数据密钥
+ *  @receiver e
+ *  @param tenantId
+ *  @param version
+ *  @param key
+ *  @return dataKey
+ */
+func (e *Envelope) cache(tenantId string, version uint32, key []byte) (dataKey cachedDataKey) {
+	dataKey = cachedDataKey{
+		version:  version,
+		key:      key,
+		expireAt: time.Now().Add(e.ttl),
+	}
+	e.mu.Lock()
+	e.keys[tenantId+":"+strconv.FormatUint(uint64(version), 10)] = dataKey
+	e.mu.Unlock()
+	return
+}
@@ -0,0 +1,37 @@
+/**
+ * @Time    :2023/7/19 11:40
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"testing"
+)
+
+func TestEnvelopeLegacyFormat(t *testing.T) {
+	e := &Envelope{}
+	sealed, err := sealGCM(bytes.Repeat([]byte{1}, dataKeySize), []byte("138"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1 := base64.StdEncoding.EncodeToString(append([]byte{envelopeFormatV1, 0, 0, 0, 1}, sealed...))
+	if !e.IsLegacyCipherTxt(v1) || e.IsCipherTxt(v1) {
+		t.Fatal("V1 cipher text not recognized as legacy")
+	}
+	if e.IsLegacyCipherTxt("13800000000") {
+		t.Error("plaintext recognized as legacy cipher text")
+	}
+	ctx := context.Background()
+	field := FieldInfo{TenantID: "t1", Table: "users", Column: "phone"}
+	if _, err = e.Decrypt(ctx, field, v1); err == nil {
+		t.Error("V1 cipher text decrypted on read")
+	}
+	field.BindPrimaryKey, field.PrimaryKey = true, "1"
+	if _, err = e.DecryptLegacy(ctx, field, v1); err == nil {
+		t.Error("V1 cipher text decrypted for a field bound to the primary key")
+	}
+}
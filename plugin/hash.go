@@ -0,0 +1,754 @@
+/**
+ * @Time    :2023/7/24 09:50
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package plugin
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/tjfoc/gmsm/sm3"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"strings"
+)
+
+const (
+	HashSM3        = "sm3"         // 加盐 SM3（默认）
+	HashHMACSHA256 = "hmac-sha256" // HMAC-SHA256，须通过 SetHasher 注册密钥
+	HashBcrypt     = "bcrypt"      // bcrypt，默认代价为 10，可通过 SetHasher 注册 NewBcryptHasher 指定代价
+	HashArgon2     = "argon2"      // argon2id，默认参数 t=1、m=64MB、p=4，可通过 SetHasher 注册 NewArgon2Hasher 指定参数
+)
+
+// SM3 哈希的盐长度
+const sm3SaltSize = 16
+
+// bcrypt 仅使用明文的前 72 字节
+const bcryptMaxSize = 72
+
+const (
+	// argon2 默认迭代次数
+	argon2Time uint32 = 1
+	// argon2 默认内存（KB）
+	argon2Memory uint32 = 64 * 1024
+	// argon2 默认并行度
+	argon2Threads uint8 = 4
+	// argon2 的盐长度
+	argon2SaltSize = 16
+	// argon2 的摘要长度
+	argon2KeySize uint32 = 32
+)
+
+var errHashFormat = errors.New("哈希值格式异常")
+
+// Hasher 单向哈希接口，用于密码、API Token 等只写不读的字段
+type Hasher interface {
+	// Hash 计算哈希值
+	Hash(plaintext string) (hashed string, err error)
+	// Verify 校验明文与哈希值是否匹配
+	Verify(hashed string, candidate string) (ok bool, err error)
+	// IsHashed 是否已是该算法的哈希值，结构体更新时不更新已是哈希值的字段，避免重复哈希
+	IsHashed(value string) bool
+}
+
+// sm3Hasher 加盐 SM3，哈希值格式为 $sm3$盐$摘要
+type sm3Hasher struct{}
+
+func (sm3Hasher) Hash(plaintext string) (hashed string, err error) {
+	salt := make([]byte, sm3SaltSize)
+	_, err = rand.Read(salt)
+	if err != nil {
+		return
+	}
+	hashed = "$" + HashSM3 + "$" + base64.RawStdEncoding.EncodeToString(salt) + "$" + base64.RawStdEncoding.EncodeToString(sm3Sum(salt, plaintext))
+	return
+}
+
+func (sm3Hasher) Verify(hashed string, candidate string) (ok bool, err error) {
+	parts := strings.Split(hashed, "$")
+	if len(parts) != 4 || parts[1] != HashSM3 {
+		err = errHashFormat
+		return
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return
+	}
+	sum, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return
+	}
+	ok = subtle.ConstantTimeCompare(sum, sm3Sum(salt, candidate)) == 1
+	return
+}
+
+func (sm3Hasher) IsHashed(value string) bool {
+	return strings.HasPrefix(value, "$"+HashSM3+"$")
+}
+
+// sm3Sum
+/**
+ *  @Description: 计算 SM3(盐|明文)
+ *  @param salt
+ *  @param plaintext
+ *  @return []byte
+ */
+func sm3Sum(salt []byte, plaintext string) []byte {
+	h := sm3.New()
+	h.Write(salt)
+	h.Write([]byte(plaintext))
+	return h.Sum(nil)
+}
+
+// hmacHasher HMAC-SHA256，哈希值格式为 $hmac-sha256$摘要，相同明文的哈希值相同
+type hmacHasher struct {
+	key []byte
+}
+
+// NewHMACSHA256Hasher
+/**
+ *  @Description: 创建 HMAC-SHA256 哈希
+ *  @param key 密钥
+ *  @return Hasher
+ */
+func NewHMACSHA256Hasher(key []byte) Hasher {
+	return hmacHasher{key: append([]byte(nil), key...)}
+}
+
+func (h hmacHasher) Hash(plaintext string) (hashed string, err error) {
+	hashed = "$" + HashHMACSHA256 + "$" + base64.RawStdEncoding.EncodeToString(h.sum(plaintext))
+	return
+}
+
+func (h hmacHasher) Verify(hashed string, candidate string) (ok bool, err error) {
+	if !h.IsHashed(hashed) {
+		err = errHashFormat
+		return
+	}
+	sum, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(hashed, "$"+HashHMACSHA256+"$"))
+	if err != nil {
+		return
+	}
+	ok = hmac.Equal(sum, h.sum(candidate))
+	return
+}
+
+func (h hmacHasher) IsHashed(value string) bool {
+	return strings.HasPrefix(value, "$"+HashHMACSHA256+"$")
+}
+
+func (h hmacHasher) sum(plaintext string) []byte {
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write([]byte(plaintext))
+	return mac.Sum(nil)
+}
+
+// bcryptHasher bcrypt，哈希值格式为 $2a$代价$盐及摘要
+type bcryptHasher struct {
+	cost int
+}
+
+// NewBcryptHasher
+/**
+ *  @Description: 创建 bcrypt 哈希
+ *  @param cost 代价，取值 4~31，超出范围时使用默认值 10
+ *  @return Hasher
+ */
+func NewBcryptHasher(cost int) Hasher {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		cost = bcrypt.DefaultCost
+	}
+	return bcryptHasher{cost: cost}
+}
+
+func (h bcryptHasher) Hash(plaintext string) (hashed string, err error) {
+	if len(plaintext) > bcryptMaxSize {
+		err = errors.New("bcrypt 的明文长度不可超过 72 字节")
+		return
+	}
+	sum, err := bcrypt.GenerateFromPassword([]byte(plaintext), h.cost)
+	if err != nil {
+		return
+	}
+	hashed = string(sum)
+	return
+}
+
+func (h bcryptHasher) Verify(hashed string, candidate string) (ok bool, err error) {
+	err = bcrypt.CompareHashAndPassword([]byte(hashed), []byte(candidate))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		err = nil
+		return
+	}
+	ok = err == nil
+	return
+}
+
+func (h bcryptHasher) IsHashed(value string) bool {
+	_, err := bcrypt.Cost([]byte(value))
+	return err == nil
+}
+
+// argon2Hasher argon2id，哈希值格式为 PHC 字符串 $argon2id$v=19$m=内存,t=迭代次数,p=并行度$盐$摘要
+type argon2Hasher struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+}
+
+// NewArgon2Hasher
+/**
+ *  @Description: 创建 argon2id 哈希
+ *  @param time 迭代次数，为 0 时使用默认值 1
+ *  @param memory 内存（KB），为 0 时使用默认值 64MB
+ *  @param threads 并行度，为 0 时使用默认值 4
+ *  @return Hasher
+ */
+func NewArgon2Hasher(time, memory uint32, threads uint8) Hasher {
+	if time == 0 {
+		time = argon2Time
+	}
+	if memory == 0 {
+		memory = argon2Memory
+	}
+	if threads == 0 {
+		threads = argon2Threads
+	}
+	return argon2Hasher{time: time, memory: memory, threads: threads}
+}
+
+func (h argon2Hasher) Hash(plaintext string) (hashed string, err error) {
+	salt := make([]byte, argon2SaltSize)
+	_, err = rand.Read(salt)
+	if err != nil {
+		return
+	}
+	key := argon2.IDKey([]byte(plaintext), salt, h.time, h.memory, h.threads, argon2KeySize)
+	hashed = fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.memory, h.time, h.threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+	return
+}
+
+func (h argon2Hasher) Verify(hashed string, candidate string) (ok bool, err error) {
+	params, salt, key, err := parseArgon2(hashed)
+	if err != nil {
+		return
+	}
+	sum := argon2.IDKey([]byte(candidate), salt, params.time, params.memory, params.threads, uint32(len(key)))
+	ok = subtle.ConstantTimeCompare(sum, key) == 1
+	return
+}
+
+func (h argon2Hasher) IsHashed(value string) bool {
+	_, _, _, err := parseArgon2(value)
+	return err == nil
+}
+
+// parseArgon2
+/**
+ *  @Description: 解析 argon2id 的 PHC 字符串
+ *  @param hashed
+ *  @return params
+ *  @return salt
+ *  @return key
+ *  @return err
+ */
+func parseArgon2(hashed string) (params argon2Hasher, salt, key []byte, err error) {
+	parts := strings.Split(hashed, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" || parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		err = errHashFormat
+		return
+	}
+	var threads uint32
+	n, scanErr := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &threads)
+	if scanErr != nil || n != 3 || params.memory == 0 || params.time == 0 || threads == 0 || threads > 255 {
+		err = errHashFormat
+		return
+	}
+	params.threads = uint8(threads)
+	salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return
+	}
+	key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err == nil && (len(salt) == 0 || len(key) == 0) {
+		err = errHashFormat
+	}
+	return
+}
+
+// SetHasher
+/**
+ *  @Description: 注册哈希算法，字段通过 mt:"hash:算法名" 使用
+ *  @receiver mt
+ *  @param name 算法名，如 bcrypt、argon2、hmac-sha256
+ *  @param hasher
+ */
+func (mt *MultiTenancy) SetHasher(name string, hasher Hasher) {
+	mt.hashMu.Lock()
+	defer mt.hashMu.Unlock()
+	if mt.hashers == nil {
+		mt.hashers = make(map[string]Hasher)
+	}
+	mt.hashers[name] = hasher
+	// 清除已解析的 mt Tag，重新校验哈希算法
+	mt.modelTagMap.Range(func(key, value interface{}) bool {
+		mt.modelTagMap.Delete(key)
+		return true
+	})
+	return
+}
+
+// hasher
+/**
+ *  @Description: 获取哈希算法，内置加盐 SM3、bcrypt、argon2id
+ *  @receiver mt
+ *  @param name
+ *  @return hasher
+ *  @return err
+ */
+func (mt *MultiTenancy) hasher(name string) (hasher Hasher, err error) {
+	mt.hashMu.RLock()
+	hasher, ok := mt.hashers[name]
+	mt.hashMu.RUnlock()
+	if ok {
+		return
+	}
+	switch name {
+	case HashSM3:
+		hasher = sm3Hasher{}
+		return
+	case HashBcrypt:
+		hasher = NewBcryptHasher(bcrypt.DefaultCost)
+		return
+	case HashArgon2:
+		hasher = NewArgon2Hasher(0, 0, 0)
+		return
+	}
+	err = mt.newError("未注册哈希算法 " + name + "，请通过 SetHasher 注册")
+	return
+}
+
+// hashValue
+/**
+ *  @Description: 计算字段值的哈希值，已是哈希格式的值同样计算哈希值，不接受调用方构造的哈希值
+ *  @receiver mt
+ *  @param ctx
+ *  @param mtTag
+ *  @param value
+ *  @return hashed
+ *  @return err
+ */
+func (mt *MultiTenancy) hashValue(ctx context.Context, mtTag MultiTenancyTag, value interface{}) (hashed string, err error) {
+	plaintext, err := marshalFieldValue(reflect.ValueOf(value))
+	if err != nil {
+		err = mt.newError(mtTag.DBName + "字段：" + err.Error())
+		return
+	}
+	hasher, err := mt.hasher(mtTag.Hash)
+	if err != nil {
+		return
+	}
+	if plaintext == "" {
+		return
+	}
+	hashed, err = hasher.Hash(plaintext)
+	if err != nil {
+		err = mt.newError("哈希异常：" + err.Error())
+		return
+	}
+	// 打印 SQL 时脱敏
+	addRedactValue(ctx, plaintext, redactedValue)
+	addRedactValue(ctx, hashed, redactedValue)
+	return
+}
+
+// hashReflectValue
+/**
+ *  @Description: 对结构体（或结构体切片）中需要哈希的字段计算哈希值，语句执行后还原明文
+ *  @receiver mt
+ *  @param db
+ *  @param sch
+ *  @param rv
+ *  @return err
+ */
+func (mt *MultiTenancy) hashReflectValue(db *gorm.DB, sch *schema.Schema, rv reflect.Value) (err error) {
+	rv = reflect.Indirect(rv)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			err = mt.hashReflectValue(db, sch, rv.Index(i))
+			if err != nil {
+				return
+			}
+		}
+	case reflect.Struct:
+		tags := mt.analyzeSchema(sch)
+		_, upsert := db.Statement.Clauses["ON CONFLICT"]
+		for _, field := range sch.Fields {
+			if !tags.needHash(field.DBName) {
+				continue
+			}
+			if upsert {
+				// 无法区分查询得到的哈希值与调用方构造的哈希值，冲突时将更新为重新计算的哈希值
+				value, _ := field.ValueOf(db.Statement.Context, rv)
+				var hashed bool
+				hashed, err = mt.isHashed(tags.tagMap[field.DBName], value)
+				if err != nil {
+					return
+				}
+				if hashed {
+					err = mt.newError(field.Name + "字段的值已是哈希值，ON CONFLICT 更新（如批量 Save）时请省略该字段")
+					return
+				}
+			}
+			err = mt.setHashData(db, tags.tagMap[field.DBName], field, rv)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// isHashed
+/**
+ *  @Description: 字段值是否已是哈希格式
+ *  @receiver mt
+ *  @param mtTag
+ *  @param value
+ *  @return hashed
+ *  @return err
+ */
+func (mt *MultiTenancy) isHashed(mtTag MultiTenancyTag, value interface{}) (hashed bool, err error) {
+	data, err := marshalFieldValue(reflect.ValueOf(value))
+	if err != nil {
+		err = mt.newError(mtTag.DBName + "字段：" + err.Error())
+		return
+	}
+	hasher, err := mt.hasher(mtTag.Hash)
+	if err != nil {
+		return
+	}
+	hashed = data != "" && hasher.IsHashed(data)
+	return
+}
+
+// setHashData
+/**
+ *  @Description: 将结构体中的字段替换为哈希值
+ *  @receiver mt
+ *  @param db
+ *  @param mtTag
+ *  @param field
+ *  @param valueOf
+ *  @return err
+ */
+func (mt *MultiTenancy) setHashData(db *gorm.DB, mtTag MultiTenancyTag, field *schema.Field, valueOf reflect.Value) (err error) {
+	if !canHoldCipherTxt(field.FieldType) {
+		err = mt.newError(field.Name + "字段须为 string 或 []byte 才能保存哈希值")
+		return
+	}
+	fieldValue, isZero := field.ValueOf(db.Statement.Context, valueOf)
+	if isZero {
+		return
+	}
+	hashed, err := mt.hashValue(db.Statement.Context, mtTag, fieldValue)
+	if err != nil {
+		return
+	}
+	return mt.setCipherTxt(db, field, valueOf, hashed)
+}
+
+// hashCreateBeforeCallback
+/**
+ *  @Description: 创建前计算哈希字段的哈希值
+ *  @receiver mt
+ *  @param db
+ */
+func (mt *MultiTenancy) hashCreateBeforeCallback(db *gorm.DB) {
+	if db.Error != nil || db.Statement.Schema == nil {
+		return
+	}
+	if len(mt.analyzeSchema(db.Statement.Schema).hashFields) == 0 {
+		return
+	}
+	mt.withRedact(db)
+	tags := mt.analyzeSchema(db.Statement.Schema)
+	for _, row := range createMaps(db.Statement.Dest) {
+		db.Error = mt.hashMap(db, tags, row)
+		if db.Error != nil {
+			return
+		}
+	}
+	db.Error = mt.hashReflectValue(db, db.Statement.Schema, db.Statement.ReflectValue)
+	if db.Error != nil {
+		return
+	}
+	// ON CONFLICT 中的更新值
+	mt.hashBySql(db)
+}
+
+// hashUpdateBeforeCallback
+/**
+ *  @Description: 更新前计算哈希字段的哈希值，支持 Map、SET 子句及结构体更新；
+ *  结构体中已是哈希格式的值（如查询后 Save）不更新该字段，避免写入调用方构造的哈希值
+ *  @receiver mt
+ *  @param db
+ */
+func (mt *MultiTenancy) hashUpdateBeforeCallback(db *gorm.DB) {
+	if db.Error != nil || db.Statement.Schema == nil {
+		return
+	}
+	tags := mt.analyzeSchema(db.Statement.Schema)
+	if len(tags.hashFields) == 0 {
+		return
+	}
+	mt.withRedact(db)
+	mt.hashBySql(db)
+	if db.Error != nil {
+		return
+	}
+	if updateInfo, ok := db.Statement.Dest.(map[string]interface{}); ok {
+		db.Error = mt.hashMap(db, tags, updateInfo)
+		return
+	}
+	updatingValue, updatingSchema, isModel := mt.updatingStruct(db)
+	if db.Error != nil || updatingSchema == nil {
+		return
+	}
+	tags = mt.analyzeSchema(updatingSchema)
+	for _, field := range updatingSchema.Fields {
+		if !tags.needHash(field.DBName) {
+			continue
+		}
+		value, isZero := field.ValueOf(db.Statement.Context, updatingValue)
+		if isZero {
+			continue
+		}
+		var hashed bool
+		hashed, db.Error = mt.isHashed(tags.tagMap[field.DBName], value)
+		if db.Error != nil {
+			return
+		}
+		if hashed {
+			mt.omitColumn(db, field.DBName)
+			continue
+		}
+		db.Error = mt.setHashData(db, tags.tagMap[field.DBName], field, updatingValue)
+		if db.Error != nil {
+			return
+		}
+		if !isModel {
+			mt.restoreModelField(db, field.DBName, value)
+		}
+	}
+}
+
+// hashMap
+/**
+ *  @Description: 计算 Map 中哈希字段的哈希值（Create、Updates），键可为字段名或数据库字段名，语句执行后还原
+ *  @receiver mt
+ *  @param db
+ *  @param tags
+ *  @param values
+ *  @return err
+ */
+func (mt *MultiTenancy) hashMap(db *gorm.DB, tags *modelTags, values map[string]interface{}) (err error) {
+	for key, value := range values {
+		column := mapColumn(db.Statement.Schema, key)
+		if !tags.needHash(column) {
+			continue
+		}
+		var hashed interface{}
+		hashed, err = mt.hashVar(db, tags, column, value)
+		if err != nil {
+			return
+		}
+		values[key] = hashed
+		mapKey, plainValue := key, value
+		mt.addRestore(db, func() { values[mapKey] = plainValue })
+		mt.restoreModelField(db, column, value)
+	}
+	return
+}
+
+// hashBySql
+/**
+ *  @Description: 计算 SET、ON CONFLICT 子句中哈希字段的哈希值，语句执行后还原
+ *  @receiver mt
+ *  @param db
+ */
+func (mt *MultiTenancy) hashBySql(db *gorm.DB) {
+	tags := mt.analyzeSchema(db.Statement.Schema)
+	for _, name := range []string{"SET", "ON CONFLICT"} {
+		c, ok := db.Statement.Clauses[name]
+		if !ok || c.Expression == nil {
+			continue
+		}
+		var expr clause.Expression
+		switch exprType := c.Expression.(type) {
+		case clause.Set:
+			expr, db.Error = mt.hashSet(db, tags, exprType)
+		case clause.OnConflict:
+			exprType.DoUpdates, db.Error = mt.hashSet(db, tags, exprType.DoUpdates)
+			expr = exprType
+		default:
+			continue
+		}
+		if db.Error != nil {
+			return
+		}
+		original := c
+		c.Expression = expr
+		db.Statement.Clauses[name] = c
+		clauseName := name
+		mt.addRestore(db, func() { db.Statement.Clauses[clauseName] = original })
+	}
+}
+
+// hashSet
+/**
+ *  @Description: 计算 SET 子句中哈希字段的哈希值，引用插入值（如 clause.AssignmentColumns）的字段已在创建时计算
+ *  @receiver mt
+ *  @param db
+ *  @param tags
+ *  @param set
+ *  @return newSet
+ *  @return err
+ */
+func (mt *MultiTenancy) hashSet(db *gorm.DB, tags *modelTags, set clause.Set) (newSet clause.Set, err error) {
+	newSet = make(clause.Set, len(set))
+	for i, assignment := range set {
+		newSet[i] = assignment
+		column := mapColumn(db.Statement.Schema, assignment.Column.Name)
+		if !tags.needHash(column) {
+			continue
+		}
+		if value, ok := assignment.Value.(clause.Column); ok && value.Table == "excluded" {
+			continue
+		}
+		newSet[i].Value, err = mt.hashVar(db, tags, column, assignment.Value)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// hashVar
+/**
+ *  @Description: 计算参数的哈希值，哈希字段不支持使用 SQL 表达式赋值
+ *  @receiver mt
+ *  @param db
+ *  @param tags
+ *  @param column
+ *  @param value
+ *  @return hashed
+ *  @return err
+ */
+func (mt *MultiTenancy) hashVar(db *gorm.DB, tags *modelTags, column string, value interface{}) (hashed interface{}, err error) {
+	switch value.(type) {
+	case nil:
+		return
+	case clause.Expression, clause.Column, *gorm.DB:
+		err = mt.newError(column + "字段声明了哈希，不支持使用 SQL 表达式赋值")
+		return
+	}
+	return mt.hashValue(db.Statement.Context, tags.tagMap[column], value)
+}
+
+// omitColumn
+/**
+ *  @Description: 更新时忽略该字段，语句执行后还原
+ *  @receiver mt
+ *  @param db
+ *  @param dbName
+ */
+func (mt *MultiTenancy) omitColumn(db *gorm.DB, dbName string) {
+	omits := db.Statement.Omits
+	db.Statement.Omits = append(omits[:len(omits):len(omits)], dbName)
+	mt.addRestore(db, func() { db.Statement.Omits = omits })
+}
+
+// VerifyHash
+/**
+ *  @Description: 使用注册的插件校验模型中哈希字段的值与明文是否匹配
+ *  @param model 查询得到的模型，如 &user
+ *  @param field 字段名或数据库字段名
+ *  @param candidate 待校验的明文
+ *  @return ok
+ *  @return err
+ */
+func VerifyHash(model interface{}, field string, candidate string) (ok bool, err error) {
+	if MTPlugin == nil {
+		err = errPluginNotRegistered
+		return
+	}
+	return MTPlugin.VerifyHash(model, field, candidate)
+}
+
+// VerifyHash
+/**
+ *  @Description: 校验模型中哈希字段的值与明文是否匹配
+ *  @receiver mt
+ *  @param model 查询得到的模型，如 &user
+ *  @param field 字段名或数据库字段名
+ *  @param candidate 待校验的明文
+ *  @return ok
+ *  @return err
+ */
+func (mt *MultiTenancy) VerifyHash(model interface{}, field string, candidate string) (ok bool, err error) {
+	ctx := context.Background()
+	tx := mt.DB.Session(&gorm.Session{NewDB: true, Context: ctx})
+	err = tx.Statement.Parse(model)
+	if err != nil {
+		return
+	}
+	sch := tx.Statement.Schema
+	schemaField := sch.LookUpField(field)
+	if schemaField == nil {
+		err = mt.newError(sch.Table + "表不存在字段" + field)
+		return
+	}
+	tags := mt.analyzeSchema(sch)
+	if tags.err != nil {
+		err = tags.err
+		return
+	}
+	mtTag := tags.tagMap[schemaField.DBName]
+	if mtTag.Hash == "" {
+		err = mt.newError(schemaField.Name + "字段未声明 mt:\"hash\"")
+		return
+	}
+	rv := reflect.Indirect(reflect.ValueOf(model))
+	if rv.Kind() != reflect.Struct {
+		err = mt.newError("model 须为结构体")
+		return
+	}
+	fieldValue, isZero := schemaField.ValueOf(ctx, rv)
+	if isZero {
+		return
+	}
+	hashed, err := marshalFieldValue(reflect.ValueOf(fieldValue))
+	if err != nil {
+		err = mt.newError(err.Error())
+		return
+	}
+	hasher, err := mt.hasher(mtTag.Hash)
+	if err != nil {
+		return
+	}
+	return hasher.Verify(hashed, candidate)
+}
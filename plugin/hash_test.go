@@ -0,0 +1,146 @@
+/**
+ * @Time    :2023/7/24 11:20
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package plugin
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type hashUser struct {
+	ID       int64
+	Name     string
+	Password string `mt:"hash"`
+}
+
+func TestBuiltinHasher(t *testing.T) {
+	for _, hasher := range []Hasher{NewBcryptHasher(4), NewArgon2Hasher(1, 64, 1)} {
+		hashed, err := hasher.Hash("secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !hasher.IsHashed(hashed) {
+			t.Errorf("%T IsHashed(%q) = false", hasher, hashed)
+		}
+		if ok, err := hasher.Verify(hashed, "secret"); err != nil || !ok {
+			t.Errorf("%T Verify = %v, %v", hasher, ok, err)
+		}
+		if ok, err := hasher.Verify(hashed, "other"); err != nil || ok {
+			t.Errorf("%T Verify mismatch = %v, %v", hasher, ok, err)
+		}
+	}
+}
+
+func TestHashUserSuppliedValue(t *testing.T) {
+	_, db := newTestDB(t, nil)
+	forged, err := sm3Hasher{}.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newTx := func() *gorm.DB {
+		return db.Session(&gorm.Session{NewDB: true, Context: context.Background()})
+	}
+	// 创建及 Map 更新时，哈希格式的值同样计算哈希值
+	for name, tx := range map[string]*gorm.DB{
+		"create":      newTx().Create(&hashUser{ID: 1, Password: forged}),
+		"updates map": newTx().Model(&hashUser{ID: 1}).Updates(map[string]interface{}{"password": forged}),
+	} {
+		if tx.Error != nil {
+			t.Fatalf("%s: %v", name, tx.Error)
+		}
+		var stored string
+		for _, v := range tx.Statement.Vars {
+			if s, ok := v.(string); ok && (sm3Hasher{}).IsHashed(s) {
+				stored = s
+			}
+		}
+		if stored == "" || stored == forged {
+			t.Fatalf("%s: forged hash stored as is: %#v", name, tx.Statement.Vars)
+		}
+		if ok, _ := (sm3Hasher{}).Verify(stored, forged); !ok {
+			t.Errorf("%s: stored value is not the hash of the supplied value", name)
+		}
+	}
+	// 结构体更新时不更新已是哈希格式的值
+	tx := newTx().Model(&hashUser{ID: 1}).Updates(hashUser{Name: "a", Password: forged})
+	if tx.Error != nil {
+		t.Fatal(tx.Error)
+	}
+	if sql := tx.Statement.SQL.String(); strings.Contains(sql, "password") {
+		t.Errorf("hash value updated: %s", sql)
+	}
+	if len(tx.Statement.Omits) != 0 {
+		t.Errorf("omits not restored: %v", tx.Statement.Omits)
+	}
+	// ON CONFLICT 更新时无法忽略该字段
+	tx = newTx().Clauses(clause.OnConflict{UpdateAll: true}).Create(&hashUser{ID: 1, Password: forged})
+	if tx.Error == nil {
+		t.Error("on conflict with hash value: expected error")
+	}
+}
+
+func TestHashWritePaths(t *testing.T) {
+	_, db := newTestDB(t, nil)
+	tests := []struct {
+		name    string
+		exec    func(tx *gorm.DB) *gorm.DB
+		wantErr bool
+	}{
+		{name: "update field name", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&hashUser{ID: 1}).Update("Password", "secret")
+		}},
+		{name: "updates field name", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&hashUser{ID: 1}).Updates(map[string]interface{}{"Password": "secret"})
+		}},
+		{name: "set clause", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&hashUser{}).Where("id = ?", 1).Clauses(clause.Set{{Column: clause.Column{Name: "password"}, Value: "secret"}}).Updates(map[string]interface{}{})
+		}},
+		{name: "create map", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&hashUser{}).Create(map[string]interface{}{"id": 1, "password": "secret"})
+		}},
+		{name: "create map slice", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&hashUser{}).Create([]map[string]interface{}{{"ID": 1, "Password": "secret"}})
+		}},
+		{name: "on conflict assignments", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Clauses(clause.OnConflict{DoUpdates: clause.Assignments(map[string]interface{}{"password": "secret"})}).Create(&hashUser{ID: 1})
+		}},
+		{name: "on conflict assignment columns", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Clauses(clause.OnConflict{DoUpdates: clause.AssignmentColumns([]string{"password"})}).Model(&hashUser{}).Create(map[string]interface{}{"id": 1, "password": "secret"})
+		}},
+		{name: "expression", wantErr: true, exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&hashUser{ID: 1}).Update("password", gorm.Expr("name"))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := tt.exec(db.Session(&gorm.Session{NewDB: true, Context: context.Background()}))
+			if (tx.Error != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", tx.Error, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			var hashed bool
+			for _, v := range tx.Statement.Vars {
+				if v == "secret" {
+					t.Fatalf("plaintext in vars %#v", tx.Statement.Vars)
+				}
+				if s, ok := v.(string); ok && (sm3Hasher{}).IsHashed(s) {
+					if ok, _ := (sm3Hasher{}).Verify(s, "secret"); ok {
+						hashed = true
+					}
+				}
+			}
+			if !hashed {
+				t.Errorf("no hash in vars %#v", tx.Statement.Vars)
+			}
+		})
+	}
+}
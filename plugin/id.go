@@ -0,0 +1,122 @@
+/**
+ * @Time    :2023/7/25 10:15
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package plugin
+
+import (
+	"github.com/melf-xyzh/multi-tenancy/id"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"reflect"
+)
+
+var distributedIdType = reflect.TypeOf(id.DistributedId(0))
+
+// SetIdGenerator
+/**
+ *  @Description: 注册全局ID生成器，创建时为未赋值的 DistributedId 主键生成分布式ID
+ *  @receiver mt
+ *  @param generator
+ */
+func (mt *MultiTenancy) SetIdGenerator(generator *id.DistributedIdGenerator) {
+	mt.SetIdGeneratorFunc(func(tenantId string) (*id.DistributedIdGenerator, error) {
+		return generator, nil
+	})
+	return
+}
+
+// SetIdGeneratorFunc
+/**
+ *  @Description: 按租户获取ID生成器，创建时为未赋值的 DistributedId 主键生成分布式ID
+ *  @receiver mt
+ *  @param generatorFunc 租户ID为语句的租户，未进行数据隔离且未通过 WithTenantId 指定时为空
+ */
+func (mt *MultiTenancy) SetIdGeneratorFunc(generatorFunc func(tenantId string) (*id.DistributedIdGenerator, error)) {
+	mt.idGenerator = generatorFunc
+	return
+}
+
+// idCreateBeforeCallback
+/**
+ *  @Description: 创建前为未赋值的 DistributedId 主键生成分布式ID
+ *  @receiver mt
+ *  @param db
+ */
+func (mt *MultiTenancy) idCreateBeforeCallback(db *gorm.DB) {
+	if db.Error != nil || mt.idGenerator == nil || db.Statement.Schema == nil {
+		return
+	}
+	field := db.Statement.Schema.PrioritizedPrimaryField
+	if field == nil || !isDistributedId(field.FieldType) {
+		return
+	}
+	generator, err := mt.idGenerator(TenantIdFromContext(db.Statement.Context))
+	if err != nil {
+		db.Error = mt.newError("获取ID生成器异常：" + err.Error())
+		return
+	}
+	if generator == nil {
+		db.Error = mt.newError("未获取到ID生成器")
+		return
+	}
+	if tags := mt.analyzeSchema(db.Statement.Schema); tags.tenantLayout && generator.Layout() != tags.layout {
+		layoutName := tags.tagMap[field.DBName].Layout
+		db.Error = mt.newError(db.Statement.Schema.Name + "的主键声明了 mt:\"layout:" + layoutName + "\"，ID生成器须使用该ID结构")
+		return
+	}
+	db.Error = mt.assignId(db, field, db.Statement.ReflectValue, generator)
+}
+
+// assignId
+/**
+ *  @Description: 为结构体（或结构体切片）中未赋值的主键生成分布式ID
+ *  @receiver mt
+ *  @param db
+ *  @param field 主键
+ *  @param rv
+ *  @param generator
+ *  @return err
+ */
+func (mt *MultiTenancy) assignId(db *gorm.DB, field *schema.Field, rv reflect.Value, generator *id.DistributedIdGenerator) (err error) {
+	rv = reflect.Indirect(rv)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			err = mt.assignId(db, field, rv.Index(i), generator)
+			if err != nil {
+				return
+			}
+		}
+	case reflect.Struct:
+		if _, isZero := field.ValueOf(db.Statement.Context, rv); !isZero {
+			return
+		}
+		var distributedId id.DistributedId
+		distributedId, err = generator.CreateIdE()
+		if err != nil {
+			err = mt.newError("生成ID异常：" + err.Error())
+			return
+		}
+		err = field.Set(db.Statement.Context, rv, distributedId)
+		if err != nil {
+			err = mt.newError("对主键赋值异常：" + err.Error())
+			return
+		}
+	}
+	return
+}
+
+// isDistributedId
+/**
+ *  @Description: 字段类型是否为 DistributedId
+ *  @param typ
+ *  @return bool
+ */
+func isDistributedId(typ reflect.Type) bool {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return typ == distributedIdType
+}
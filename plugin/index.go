@@ -0,0 +1,247 @@
+/**
+ * @Time    :2023/7/11 15:20
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package plugin
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/binary"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+)
+
+// SetIndexKey
+/**
+ *  @Description: 设置查询索引（mt:"encrypt;index:字段名"）的密钥，索引字段保存 HMAC-SHA256(密钥, 租户ID|表名|字段名|明文)
+ *  @receiver mt
+ *  @param key 密钥，建议不少于 32 字节，更换后须重新计算已有数据的索引
+ */
+func (mt *MultiTenancy) SetIndexKey(key []byte) {
+	mt.indexKey = append([]byte(nil), key...)
+	return
+}
+
+// indexValue
+/**
+ *  @Description: 计算明文的查询索引，空值的索引为空字符串
+ *  @receiver mt
+ *  @param ctx
+ *  @param mtTag 加密字段的 mt Tag
+ *  @param value 明文
+ *  @return index
+ *  @return err
+ */
+func (mt *MultiTenancy) indexValue(ctx context.Context, mtTag MultiTenancyTag, value interface{}) (index string, err error) {
+	if len(mt.indexKey) == 0 {
+		err = mt.newError(mtTag.DBName + "字段声明了查询索引，请通过 SetIndexKey 设置索引密钥")
+		return
+	}
+	data, err := marshalFieldValue(reflect.ValueOf(value))
+	if err != nil {
+		err = mt.newError(mtTag.DBName + "字段：" + err.Error())
+		return
+	}
+	if data == "" {
+		return
+	}
+	h := hmac.New(sha256.New, mt.indexKey)
+	// 各部分带长度前缀，相同明文在不同租户、表、字段下的索引不同
+	for _, part := range []string{TenantIdFromContext(ctx), mtTag.Table, mtTag.DBName} {
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(part)))
+		h.Write(size[:])
+		h.Write([]byte(part))
+	}
+	h.Write([]byte(data))
+	index = base64.RawStdEncoding.EncodeToString(h.Sum(nil))
+	return
+}
+
+// indexVar
+/**
+ *  @Description: 计算参数（查询条件、更新值）的查询索引，切片逐个计算；SQL 表达式、子查询无法计算索引，返回错误
+ *  @receiver mt
+ *  @param db
+ *  @param tags
+ *  @param column 加密字段的 DBName
+ *  @param value
+ *  @return index
+ *  @return err
+ */
+func (mt *MultiTenancy) indexVar(db *gorm.DB, tags *modelTags, column string, value interface{}) (index interface{}, err error) {
+	switch value.(type) {
+	case nil:
+		return
+	case clause.Expression, clause.Column, *gorm.DB:
+		err = mt.newError(column + "字段声明了查询索引，不支持使用 SQL 表达式")
+		return
+	case driver.Valuer:
+		// 结构体条件中使用加密序列化器的字段已在 Value 时加密，无法获取明文
+		if field := db.Statement.Schema.LookUpField(column); field != nil && field.Serializer != nil {
+			err = mt.newError(column + "字段声明了查询索引，请使用 Map 或字符串条件查询")
+			return
+		}
+	}
+	rv := reflect.ValueOf(value)
+	if (rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() != reflect.Uint8) || rv.Kind() == reflect.Array {
+		values := make([]interface{}, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			values[i], err = mt.indexVar(db, tags, column, rv.Index(i).Interface())
+			if err != nil {
+				return
+			}
+		}
+		return values, nil
+	}
+	return mt.indexValue(db.Statement.Context, tags.tagMap[column], value)
+}
+
+// indexReflectValue
+/**
+ *  @Description: 按结构体中加密字段的明文计算查询索引并赋值到索引字段，须在加密前调用
+ *  @receiver mt
+ *  @param db
+ *  @param sch
+ *  @param table 语句模型的表名，使用其他结构体更新时与 sch 的表名不同
+ *  @param rv
+ *  @return err
+ */
+func (mt *MultiTenancy) indexReflectValue(db *gorm.DB, sch *schema.Schema, table string, rv reflect.Value) (err error) {
+	tags := mt.analyzeSchema(sch)
+	if len(tags.indexColumns) == 0 {
+		return
+	}
+	rv = reflect.Indirect(rv)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			err = mt.indexReflectValue(db, sch, table, rv.Index(i))
+			if err != nil {
+				return
+			}
+		}
+	case reflect.Struct:
+		ctx := db.Statement.Context
+		for column, indexColumn := range tags.indexColumns {
+			value, isZero := sch.FieldsByDBName[column].ValueOf(ctx, rv)
+			var index string
+			if !isZero {
+				mtTag := tags.tagMap[column]
+				mtTag.Table = table
+				index, err = mt.indexValue(ctx, mtTag, value)
+				if err != nil {
+					return
+				}
+			}
+			err = sch.FieldsByDBName[indexColumn].Set(ctx, rv, index)
+			if err != nil {
+				err = mt.newError("对结构体赋值异常：" + err.Error())
+				return
+			}
+		}
+	}
+	return
+}
+
+// indexMap
+/**
+ *  @Description: 按 Map 中加密字段的明文计算查询索引并写入索引字段，须在加密前调用，语句执行后还原
+ *  @receiver mt
+ *  @param db
+ *  @param tags
+ *  @param values
+ *  @return err
+ */
+func (mt *MultiTenancy) indexMap(db *gorm.DB, tags *modelTags, values map[string]interface{}) (err error) {
+	if len(tags.indexColumns) == 0 {
+		return
+	}
+	indexes := make(map[string]interface{})
+	for key, value := range values {
+		column := mapColumn(db.Statement.Schema, key)
+		indexColumn := tags.indexColumn(column)
+		if indexColumn == "" {
+			continue
+		}
+		indexes[indexColumn], err = mt.indexVar(db, tags, column, value)
+		if err != nil {
+			return
+		}
+	}
+	for key := range values {
+		// 调用方已指定索引字段（字段名）时覆盖
+		if column := mapColumn(db.Statement.Schema, key); column != key {
+			if index, ok := indexes[column]; ok {
+				delete(indexes, column)
+				indexes[key] = index
+			}
+		}
+	}
+	for key, index := range indexes {
+		original, exists := values[key]
+		values[key] = index
+		mapKey := key
+		mt.addRestore(db, func() {
+			if exists {
+				values[mapKey] = original
+			} else {
+				delete(values, mapKey)
+			}
+		})
+	}
+	return
+}
+
+// indexSet
+/**
+ *  @Description: 为 SET 子句中声明了查询索引的加密字段赋值索引字段（已指定时覆盖），须在加密前调用；
+ *  引用插入值（如 clause.AssignmentColumns）时索引字段同样引用插入值
+ *  @receiver mt
+ *  @param db
+ *  @param tags
+ *  @param set
+ *  @return newSet
+ *  @return err
+ */
+func (mt *MultiTenancy) indexSet(db *gorm.DB, tags *modelTags, set clause.Set) (newSet clause.Set, err error) {
+	newSet = set
+	if len(tags.indexColumns) == 0 {
+		return
+	}
+	// 复制一份，避免修改调用方传入的子句
+	newSet = append(clause.Set{}, set...)
+	for _, assignment := range set {
+		indexColumn := tags.indexColumn(assignment.Column.Name)
+		if indexColumn == "" {
+			continue
+		}
+		index := clause.Assignment{Column: clause.Column{Table: assignment.Column.Table, Name: indexColumn}}
+		if value, ok := assignment.Value.(clause.Column); ok && value.Table == "excluded" {
+			index.Value = clause.Column{Table: value.Table, Name: indexColumn}
+		} else {
+			index.Value, err = mt.indexVar(db, tags, assignment.Column.Name, assignment.Value)
+			if err != nil {
+				return
+			}
+		}
+		assigned := false
+		for i := range newSet {
+			if newSet[i].Column.Name == indexColumn {
+				newSet[i] = index
+				assigned = true
+			}
+		}
+		if !assigned {
+			newSet = append(newSet, index)
+		}
+	}
+	return
+}
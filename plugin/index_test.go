@@ -0,0 +1,170 @@
+/**
+ * @Time    :2023/7/11 16:40
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package plugin
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type indexedUser struct {
+	ID       int64
+	Name     string
+	Phone    string `mt:"encrypt;mode:random;index:phone_idx"`
+	PhoneIdx string
+}
+
+func TestIndexWrite(t *testing.T) {
+	mt, db := newTestDB(t, nil)
+	mt.SetIndexKey([]byte("0123456789abcdef0123456789abcdef"))
+	tx, tags := testStatement(t, mt, db, &indexedUser{})
+	index, err := mt.indexValue(tx.Statement.Context, tags.tagMap["phone"], "138")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		exec func(tx *gorm.DB) *gorm.DB
+	}{
+		{name: "create", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Create(&indexedUser{ID: 1, Phone: "138"})
+		}},
+		{name: "create map", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&indexedUser{}).Create(map[string]interface{}{"ID": 1, "Phone": "138"})
+		}},
+		{name: "update", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&indexedUser{ID: 1}).Update("phone", "138")
+		}},
+		{name: "updates struct", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&indexedUser{ID: 1}).Updates(indexedUser{Phone: "138"})
+		}},
+		{name: "set clause", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&indexedUser{}).Where("id = ?", 1).Clauses(clause.Set{{Column: clause.Column{Name: "phone"}, Value: "138"}}).Updates(map[string]interface{}{})
+		}},
+		{name: "on conflict", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Clauses(clause.OnConflict{DoUpdates: clause.Assignments(map[string]interface{}{"phone": "138"})}).Create(&indexedUser{ID: 1, Name: "a"})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := tt.exec(db.Session(&gorm.Session{NewDB: true, Context: context.Background()}))
+			if tx.Error != nil {
+				t.Fatal(tx.Error)
+			}
+			if !strings.Contains(tx.Statement.SQL.String(), "`phone_idx`") {
+				t.Errorf("index column not written: %s", tx.Statement.SQL.String())
+			}
+			var indexed bool
+			for _, v := range tx.Statement.Vars {
+				if v == "138" {
+					t.Fatalf("plaintext in vars %#v", tx.Statement.Vars)
+				}
+				indexed = indexed || v == index
+			}
+			if !indexed {
+				t.Errorf("no index in vars %#v", tx.Statement.Vars)
+			}
+		})
+	}
+}
+
+func TestIndexCondition(t *testing.T) {
+	mt, db := newTestDB(t, nil)
+	mt.SetIndexKey([]byte("0123456789abcdef0123456789abcdef"))
+	tx, tags := testStatement(t, mt, db, &indexedUser{})
+	index, err := mt.indexValue(tx.Statement.Context, tags.tagMap["phone"], "138")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		exec    func(tx *gorm.DB) *gorm.DB
+		wantSql string
+		wantErr bool
+	}{
+		{name: "string", wantSql: "WHERE `users`.`phone_idx` = ?", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Table("users").Where("`users`.`phone` = ?", "138").Find(&[]indexedUser{})
+		}},
+		{name: "in", wantSql: "WHERE phone_idx IN (?,?)", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Where("phone IN ?", []string{"138", "139"}).Find(&[]indexedUser{})
+		}},
+		{name: "struct", wantSql: "WHERE `indexed_users`.`phone_idx` = ?", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Where(&indexedUser{Phone: "138"}).Find(&[]indexedUser{})
+		}},
+		{name: "map", wantSql: "WHERE `phone_idx` = ?", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Where(map[string]interface{}{"phone": "138"}).Find(&[]indexedUser{})
+		}},
+		{name: "not", wantSql: "WHERE `phone_idx` <> ?", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Not(map[string]interface{}{"phone": "138"}).Find(&[]indexedUser{})
+		}},
+		{name: "named", wantSql: "WHERE phone_idx = ?", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Where("phone = @p", sql.Named("p", "138")).Find(&[]indexedUser{})
+		}},
+		{name: "subquery", wantErr: true, exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Where("phone = ?", tx.Session(&gorm.Session{NewDB: true}).Model(&indexedUser{}).Select("phone")).Find(&[]indexedUser{})
+		}},
+		{name: "like", wantErr: true, exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Where("phone LIKE ?", "13%").Find(&[]indexedUser{})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := tt.exec(db.Session(&gorm.Session{NewDB: true, Context: context.Background()}))
+			if (tx.Error != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", tx.Error, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !strings.Contains(tx.Statement.SQL.String(), tt.wantSql) {
+				t.Errorf("sql = %s, want %s", tx.Statement.SQL.String(), tt.wantSql)
+			}
+			if tx.Statement.Vars[0] != index {
+				t.Errorf("vars = %#v", tx.Statement.Vars)
+			}
+		})
+	}
+}
+
+type badIndexUser struct {
+	ID    int64
+	Phone string `mt:"encrypt;index:phone_idx"`
+}
+
+type badIndexTypeUser struct {
+	ID       int64
+	Phone    string `mt:"encrypt;index:PhoneIdx"`
+	PhoneIdx int64
+}
+
+type sharedIndexUser struct {
+	ID       int64
+	Phone    string `mt:"encrypt;index:phone_idx"`
+	Mobile   string `mt:"encrypt;index:phone_idx"`
+	PhoneIdx string
+}
+
+func TestIndexValidation(t *testing.T) {
+	mt, db := newTestDB(t, nil)
+	for _, model := range []interface{}{&badIndexUser{}, &badIndexTypeUser{}, &sharedIndexUser{}} {
+		if _, err := mt.ModelTags(model); err == nil {
+			t.Errorf("%T: expected error", model)
+		}
+	}
+	if _, err := ParseTag("index:phone_idx"); err == nil {
+		t.Error("index without encrypt: expected error")
+	}
+	// 未设置索引密钥
+	tx := db.Session(&gorm.Session{NewDB: true, Context: context.Background()}).Create(&indexedUser{ID: 1, Phone: "138"})
+	if tx.Error == nil {
+		t.Error("missing index key: expected error")
+	}
+}
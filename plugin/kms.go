@@ -0,0 +1,163 @@
+/**
+ * @Time    :2023/7/19 09:52
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package plugin
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"sync"
+)
+
+// KMS 密钥管理服务，使用主密钥加密（包装）及解密（解包）数据密钥；
+// aad 为附加认证数据（如云厂商 KMS 的加密上下文），包含数据密钥所属的租户ID及版本，解包时须一致
+type KMS interface {
+	WrapKey(ctx context.Context, masterKeyId string, dataKey, aad []byte) (cipherKey []byte, err error)
+	UnwrapKey(ctx context.Context, masterKeyId string, cipherKey, aad []byte) (dataKey []byte, err error)
+}
+
+// LocalKMS 本地密钥管理服务，主密钥保存在内存中，用于测试及开发环境
+type LocalKMS struct {
+	mu         sync.RWMutex
+	masterKeys map[string][]byte // 主密钥ID -> 主密钥
+}
+
+// NewLocalKMS
+/**
+ *  @Description: 创建本地密钥管理服务
+ *  @return *LocalKMS
+ */
+func NewLocalKMS() *LocalKMS {
+	return &LocalKMS{masterKeys: make(map[string][]byte)}
+}
+
+// NewFileKMS
+/**
+ *  @Description: 从文件加载主密钥，文件内容为 JSON：{"主密钥ID": "Base64 编码的主密钥"}
+ *  @param path 文件路径
+ *  @return kms
+ *  @return err
+ */
+func NewFileKMS(path string) (kms *LocalKMS, err error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	keys := make(map[string]string)
+	err = json.Unmarshal(content, &keys)
+	if err != nil {
+		return
+	}
+	kms = NewLocalKMS()
+	for masterKeyId, encoded := range keys {
+		var key []byte
+		key, err = base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.New("主密钥 " + masterKeyId + " 解析异常：" + err.Error())
+		}
+		err = kms.AddMasterKey(masterKeyId, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// AddMasterKey
+/**
+ *  @Description: 添加主密钥
+ *  @receiver k
+ *  @param masterKeyId 主密钥ID
+ *  @param key 主密钥，长度为 16、24、32
+ *  @return err
+ */
+func (k *LocalKMS) AddMasterKey(masterKeyId string, key []byte) (err error) {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		err = errors.New("主密钥 " + masterKeyId + " 长度须为 16、24、32")
+		return
+	}
+	k.mu.Lock()
+	k.masterKeys[masterKeyId] = append([]byte(nil), key...)
+	k.mu.Unlock()
+	return
+}
+
+// masterKey
+/**
+ *  @Description: 获取主密钥
+ *  @receiver k
+ *  @param masterKeyId
+ *  @return key
+ *  @return err
+ */
+func (k *LocalKMS) masterKey(masterKeyId string) (key []byte, err error) {
+	k.mu.RLock()
+	key, ok := k.masterKeys[masterKeyId]
+	k.mu.RUnlock()
+	if !ok {
+		err = errors.New("主密钥 " + masterKeyId + " 不存在")
+	}
+	return
+}
+
+// WrapKey
+/**
+ *  @Description: 使用主密钥加密数据密钥
+ *  @receiver k
+ *  @param ctx
+ *  @param masterKeyId
+ *  @param dataKey
+ *  @param aad 附加认证数据
+ *  @return cipherKey
+ *  @return err
+ */
+func (k *LocalKMS) WrapKey(ctx context.Context, masterKeyId string, dataKey, aad []byte) (cipherKey []byte, err error) {
+	key, err := k.masterKey(masterKeyId)
+	if err != nil {
+		return
+	}
+	return sealGCM(key, dataKey, wrapAAD(masterKeyId, aad))
+}
+
+// UnwrapKey
+/**
+ *  @Description: 使用主密钥解密数据密钥
+ *  @receiver k
+ *  @param ctx
+ *  @param masterKeyId
+ *  @param cipherKey
+ *  @param aad 附加认证数据，须与包装时一致
+ *  @return dataKey
+ *  @return err
+ */
+func (k *LocalKMS) UnwrapKey(ctx context.Context, masterKeyId string, cipherKey, aad []byte) (dataKey []byte, err error) {
+	key, err := k.masterKey(masterKeyId)
+	if err != nil {
+		return
+	}
+	return openGCM(key, cipherKey, wrapAAD(masterKeyId, aad))
+}
+
+// wrapAAD
+/**
+ *  @Description: 生成包装数据密钥的附加认证数据：主密钥ID（带长度前缀）|aad；aad 为空时仅为主密钥ID，兼容未绑定租户及版本的数据密钥
+ *  @param masterKeyId
+ *  @param aad
+ *  @return []byte
+ */
+func wrapAAD(masterKeyId string, aad []byte) []byte {
+	if len(aad) == 0 {
+		return []byte(masterKeyId)
+	}
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(masterKeyId)))
+	return append(append(size[:], masterKeyId...), aad...)
+}
@@ -0,0 +1,49 @@
+/**
+ * @Time    :2023/7/19 11:20
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestLocalKMSWrapAAD(t *testing.T) {
+	kms := NewLocalKMS()
+	if err := kms.AddMasterKey("master-1", bytes.Repeat([]byte{1}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	dataKey := bytes.Repeat([]byte{2}, dataKeySize)
+	cipherKey, err := kms.WrapKey(ctx, "master-1", dataKey, dataKeyAAD("t1", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := kms.UnwrapKey(ctx, "master-1", cipherKey, dataKeyAAD("t1", 1))
+	if err != nil || !bytes.Equal(got, dataKey) {
+		t.Fatalf("unwrap = %x, %v", got, err)
+	}
+	// 数据密钥被复制到其他租户、版本，或按旧格式解包时失败
+	for _, aad := range [][]byte{dataKeyAAD("t2", 1), dataKeyAAD("t1", 2), nil} {
+		if _, err = kms.UnwrapKey(ctx, "master-1", cipherKey, aad); err == nil {
+			t.Errorf("unwrap with aad %x succeeded", aad)
+		}
+	}
+	// 旧版本的数据密钥仅绑定主密钥ID
+	legacyKey, err := sealGCM(bytes.Repeat([]byte{1}, 32), dataKey, []byte("master-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err = kms.UnwrapKey(ctx, "master-1", legacyKey, nil); err != nil || !bytes.Equal(got, dataKey) {
+		t.Fatalf("unwrap legacy = %x, %v", got, err)
+	}
+}
+
+func TestDataKeyAAD(t *testing.T) {
+	if bytes.Equal(dataKeyAAD("t1", 12), dataKeyAAD("t11", 2)) {
+		t.Error("tenant and version are ambiguous")
+	}
+}
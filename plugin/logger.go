@@ -0,0 +1,142 @@
+/**
+ * @Time    :2023/7/18 15:46
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package plugin
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"sync"
+)
+
+type redactContextKey struct{}
+
+// redactValues 语句中加密字段的明文及密文，打印 SQL 时替换
+type redactValues struct {
+	sync.Mutex
+	values map[string]string
+}
+
+// withRedact
+/**
+ *  @Description: 在语句的 Context 中记录需要脱敏的值
+ *  @receiver mt
+ *  @param db
+ */
+func (mt *MultiTenancy) withRedact(db *gorm.DB) {
+	ctx := db.Statement.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if _, ok := ctx.Value(redactContextKey{}).(*redactValues); ok {
+		return
+	}
+	db.Statement.Context = context.WithValue(ctx, redactContextKey{}, &redactValues{values: make(map[string]string)})
+}
+
+// addRedactValue
+/**
+ *  @Description: 记录需要脱敏的值
+ *  @param ctx
+ *  @param value
+ *  @param replacement 替换值
+ */
+func addRedactValue(ctx context.Context, value string, replacement string) {
+	if ctx == nil || value == "" {
+		return
+	}
+	redact, ok := ctx.Value(redactContextKey{}).(*redactValues)
+	if !ok {
+		return
+	}
+	redact.Lock()
+	redact.values[value] = replacement
+	redact.Unlock()
+}
+
+// redactLogger 对加密字段脱敏的日志
+type redactLogger struct {
+	logger.Interface
+}
+
+// NewRedactLogger
+/**
+ *  @Description: 包装 GORM 日志，打印 SQL 时对加密字段的明文及密文脱敏
+ *  @param l
+ *  @return logger.Interface
+ */
+func NewRedactLogger(l logger.Interface) logger.Interface {
+	return &redactLogger{Interface: l}
+}
+
+func (l *redactLogger) LogMode(level logger.LogLevel) logger.Interface {
+	return &redactLogger{Interface: l.Interface.LogMode(level)}
+}
+
+// ParamsFilter
+/**
+ *  @Description: 替换 SQL 参数中加密字段的值
+ *  @receiver l
+ *  @param ctx
+ *  @param sql
+ *  @param params
+ *  @return string
+ *  @return []interface{}
+ */
+func (l *redactLogger) ParamsFilter(ctx context.Context, sql string, params ...interface{}) (string, []interface{}) {
+	redact, _ := ctx.Value(redactContextKey{}).(*redactValues)
+	newParams := make([]interface{}, len(params))
+	for i, param := range params {
+		newParams[i] = param
+		if isEncryptedSerializerValue(param) {
+			newParams[i] = redactedValue
+			continue
+		}
+		if redact == nil {
+			continue
+		}
+		var value string
+		switch v := param.(type) {
+		case string:
+			value = v
+		case []byte:
+			value = string(v)
+		default:
+			continue
+		}
+		redact.Lock()
+		replacement, ok := redact.values[value]
+		redact.Unlock()
+		if ok {
+			newParams[i] = replacement
+		}
+	}
+	if filter, ok := l.Interface.(gorm.ParamsFilter); ok {
+		return filter.ParamsFilter(ctx, sql, newParams...)
+	}
+	return sql, newParams
+}
+
+// isEncryptedSerializerValue
+/**
+ *  @Description: 参数是否为使用加密序列化器的字段值（GORM 序列化器包装，打印时才会加密）
+ *  @param param
+ *  @return bool
+ */
+func isEncryptedSerializerValue(param interface{}) bool {
+	rv := reflect.ValueOf(param)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return false
+	}
+	fieldValue := rv.Elem().FieldByName("Field")
+	if !fieldValue.IsValid() {
+		return false
+	}
+	field, ok := fieldValue.Interface().(*schema.Field)
+	return ok && field != nil && isEncryptSerializer(field)
+}
@@ -0,0 +1,118 @@
+/**
+ * @Time    :2023/7/18 14:20
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package plugin
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"reflect"
+	"strings"
+)
+
+const (
+	MaskPhone    = "phone"    // 手机号：138****1234
+	MaskIdCard   = "idcard"   // 身份证号：110***********1234
+	MaskEmail    = "email"    // 邮箱：a***@example.com
+	MaskName     = "name"     // 姓名：张**
+	MaskBankCard = "bankcard" // 银行卡号：************1234
+)
+
+// 未指定脱敏方式时的替换值
+const redactedValue = "******"
+
+// Mask
+/**
+ *  @Description: 按脱敏方式对数据脱敏，未知的脱敏方式将整体替换
+ *  @param maskType 脱敏方式
+ *  @param value
+ *  @return string
+ */
+func Mask(maskType string, value string) string {
+	runes := []rune(value)
+	switch maskType {
+	case MaskPhone:
+		if len(runes) >= 7 {
+			return maskRunes(runes, 3, 4)
+		}
+	case MaskIdCard:
+		if len(runes) >= 8 {
+			return maskRunes(runes, 3, 4)
+		}
+	case MaskEmail:
+		if index := strings.LastIndex(value, "@"); index > 0 {
+			local := []rune(value[:index])
+			return string(local[:1]) + "***" + value[index:]
+		}
+	case MaskName:
+		if len(runes) > 1 {
+			return maskRunes(runes, 1, 0)
+		}
+	case MaskBankCard:
+		if len(runes) > 4 {
+			return maskRunes(runes, 0, 4)
+		}
+	}
+	return redactedValue
+}
+
+// maskRunes
+/**
+ *  @Description: 保留首尾指定长度，其余替换为*
+ *  @param runes
+ *  @param head
+ *  @param tail
+ *  @return string
+ */
+func maskRunes(runes []rune, head, tail int) string {
+	return string(runes[:head]) + strings.Repeat("*", len(runes)-head-tail) + string(runes[len(runes)-tail:])
+}
+
+// MaskDest
+/**
+ *  @Description: 对结构体（或结构体切片）中声明了 mask 的 string 字段脱敏，用于接口返回
+ *  @receiver mt
+ *  @param dest 须为指针
+ *  @return err
+ */
+func (mt *MultiTenancy) MaskDest(dest interface{}) (err error) {
+	tx := mt.DB.Session(&gorm.Session{NewDB: true, Context: context.Background()})
+	err = tx.Statement.Parse(dest)
+	if err != nil {
+		return
+	}
+	sch := tx.Statement.Schema
+	tags := mt.analyzeSchema(sch)
+	if tags.err != nil {
+		return tags.err
+	}
+	var maskValue func(rv reflect.Value)
+	maskValue = func(rv reflect.Value) {
+		rv = reflect.Indirect(rv)
+		switch rv.Kind() {
+		case reflect.Slice, reflect.Array:
+			for i := 0; i < rv.Len(); i++ {
+				maskValue(rv.Index(i))
+			}
+		case reflect.Struct:
+			if !rv.CanAddr() {
+				return
+			}
+			for _, field := range sch.Fields {
+				mtTag := tags.tagMap[field.DBName]
+				if mtTag.Mask == "" {
+					continue
+				}
+				fieldValue := reflect.Indirect(field.ReflectValueOf(tx.Statement.Context, rv))
+				if fieldValue.Kind() != reflect.String || fieldValue.Len() == 0 {
+					continue
+				}
+				fieldValue.SetString(Mask(mtTag.Mask, fieldValue.String()))
+			}
+		}
+	}
+	maskValue(reflect.ValueOf(dest))
+	return
+}
@@ -0,0 +1,267 @@
+/**
+ * @Time    :2023/7/20 14:05
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"reflect"
+)
+
+// 存量数据加密默认每批处理的行数
+const defaultEncryptBatchSize = 500
+
+// EncryptExistingOptions 存量明文数据加密配置
+type EncryptExistingOptions struct {
+	Table          string   // 表名，默认为模型的表名
+	PrimaryKey     string   // 主键字段名，默认为模型的主键
+	Columns        []string // 需要加密的字段，默认为模型中全部加密字段
+	Storage        string   // 未指定模型时密文的存储方式：text（默认）、binary
+	BindPrimaryKey bool     // 未指定模型时密文是否绑定所在行的主键，须与字段的 mt:"encrypt;bind" 声明一致
+	TenantColumn   string   // 租户字段名，数据隔离的模型默认为数据隔离字段标识
+	TenantIds      []string // 按租户分批加密，数据隔离的模型须指定
+	BatchSize      int      // 每批处理的行数，默认为500
+	DryRun         bool     // 仅统计，不写入数据库
+}
+
+// EncryptExistingResult 存量明文数据加密结果
+type EncryptExistingResult struct {
+	Scanned     int64 // 扫描的字段值数量
+	Encrypted   int64 // 加密的明文数量
+	Reencrypted int64 // 解密后按当前格式重新加密的旧版本密文数量
+	Skipped     int64 // 已是密文或为空值而跳过的数量
+}
+
+// EncryptExisting
+/**
+ *  @Description: 加密表中存量的明文数据，已加密的数据将被跳过，旧版本的密文（LegacyCipher）解密后按当前格式重新加密，可重复执行
+ *  @receiver mt
+ *  @param ctx
+ *  @param model 模型，如 &User{}；为 nil 时须在 opts 中指定表名、主键及字段
+ *  @param opts
+ *  @return result
+ *  @return err
+ */
+func (mt *MultiTenancy) EncryptExisting(ctx context.Context, model interface{}, opts EncryptExistingOptions) (result EncryptExistingResult, err error) {
+	if !mt.encryptedSave {
+		err = mt.newError("未开启加密存储")
+		return
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	tagMap := make(map[string]MultiTenancyTag)
+	if model != nil {
+		tx := mt.DB.Session(&gorm.Session{NewDB: true, Context: ctx})
+		err = tx.Statement.Parse(model)
+		if err != nil {
+			return
+		}
+		sch := tx.Statement.Schema
+		tags := mt.analyzeSchema(sch)
+		if tags.err != nil {
+			err = tags.err
+			return
+		}
+		if opts.Table == "" {
+			opts.Table = sch.Table
+		}
+		if opts.PrimaryKey == "" && sch.PrioritizedPrimaryField != nil {
+			opts.PrimaryKey = sch.PrioritizedPrimaryField.DBName
+		}
+		if len(opts.Columns) == 0 {
+			for _, field := range sch.Fields {
+				if tags.needEncrypt(field.DBName) {
+					opts.Columns = append(opts.Columns, field.DBName)
+				}
+			}
+		}
+		for _, field := range sch.Fields {
+			mtTag, ok := tags.tagMap[field.DBName]
+			if !ok {
+				continue
+			}
+			// 直接存放密文的字段按字段原始类型存储
+			if field.Serializer == nil {
+				mtTag.Storage = storageText
+			}
+			tagMap[field.DBName] = mtTag
+		}
+		if isolatedModel, ok := mt.dataIsolation[opts.Table]; ok && isolatedModel.DataIsolation() && opts.TenantColumn == "" {
+			opts.TenantColumn = mt.schemaTenantColumn(sch)
+		}
+	}
+	if opts.Table == "" || opts.PrimaryKey == "" || len(opts.Columns) == 0 {
+		err = mt.newError("存量数据加密须指定表名、主键及加密字段")
+		return
+	}
+	if opts.TenantColumn != "" && len(opts.TenantIds) == 0 {
+		err = mt.newError("数据隔离的表须指定租户")
+		return
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultEncryptBatchSize
+	}
+	for _, column := range opts.Columns {
+		if _, ok := tagMap[column]; !ok {
+			tagMap[column] = MultiTenancyTag{Table: opts.Table, DBName: column, Encrypt: true, Storage: opts.Storage, Bind: opts.BindPrimaryKey}
+		}
+	}
+	tenantIds := opts.TenantIds
+	if len(tenantIds) == 0 {
+		tenantIds = []string{""}
+	}
+	for _, tenantId := range tenantIds {
+		err = mt.encryptExistingTenant(WithTenantId(ctx, tenantId), tenantId, tagMap, opts, &result)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// encryptExistingTenant
+/**
+ *  @Description: 按主键顺序分批加密租户的存量明文数据
+ *  @receiver mt
+ *  @param ctx
+ *  @param tenantId
+ *  @param tagMap 加密字段的 mt Tag
+ *  @param opts
+ *  @param result
+ *  @return err
+ */
+func (mt *MultiTenancy) encryptExistingTenant(ctx context.Context, tenantId string, tagMap map[string]MultiTenancyTag, opts EncryptExistingOptions, result *EncryptExistingResult) (err error) {
+	selects := append([]string{opts.PrimaryKey}, opts.Columns...)
+	var lastKey interface{}
+	for {
+		// 数据隔离的表通过租户条件切换数据库
+		tx := mt.DB.WithContext(ctx).Table(opts.Table)
+		if opts.TenantColumn != "" {
+			tx = tx.Where(opts.TenantColumn+" = ?", tenantId)
+		}
+		if lastKey != nil {
+			tx = tx.Where(opts.PrimaryKey+" > ?", lastKey)
+		}
+		var rows []map[string]interface{}
+		err = tx.Select(selects).Order(opts.PrimaryKey).Limit(opts.BatchSize).Find(&rows).Error
+		if err != nil {
+			return
+		}
+		for _, row := range rows {
+			lastKey = row[opts.PrimaryKey]
+			rowKey, _ := marshalFieldValue(reflect.ValueOf(lastKey))
+			updates := make(map[string]interface{})
+			for _, column := range opts.Columns {
+				result.Scanned++
+				// 未绑定主键的字段加解密时忽略主键
+				var cipherValue interface{}
+				var legacy bool
+				cipherValue, legacy, err = mt.encryptPlaintext(ctx, tagMap[column], rowKey, row[column])
+				if err != nil {
+					return
+				}
+				switch {
+				case cipherValue == nil:
+					result.Skipped++
+					continue
+				case legacy:
+					result.Reencrypted++
+				default:
+					result.Encrypted++
+				}
+				updates[column] = cipherValue
+			}
+			if len(updates) == 0 {
+				continue
+			}
+			if opts.DryRun {
+				continue
+			}
+			tx = mt.DB.WithContext(ctx).Table(opts.Table)
+			if opts.TenantColumn != "" {
+				tx = tx.Where(opts.TenantColumn+" = ?", tenantId)
+			}
+			err = tx.Where(opts.PrimaryKey+" = ?", lastKey).UpdateColumns(updates).Error
+			if err != nil {
+				return
+			}
+		}
+		if len(rows) < opts.BatchSize {
+			return
+		}
+	}
+}
+
+// encryptPlaintext
+/**
+ *  @Description: 加密数据库中的明文，旧版本的密文解密后重新加密，空值及已是密文的值返回 nil
+ *  @receiver mt
+ *  @param ctx
+ *  @param mtTag
+ *  @param primaryKey 所在行的主键
+ *  @param dbValue
+ *  @return cipherValue
+ *  @return legacy 是否为重新加密的旧版本密文
+ *  @return err
+ */
+func (mt *MultiTenancy) encryptPlaintext(ctx context.Context, mtTag MultiTenancyTag, primaryKey string, dbValue interface{}) (cipherValue interface{}, legacy bool, err error) {
+	if dbValue == nil {
+		return
+	}
+	data, err := marshalFieldValue(reflect.ValueOf(dbValue))
+	if err != nil {
+		err = mt.newError(mtTag.DBName + "字段：" + err.Error())
+		return
+	}
+	if data == "" {
+		return
+	}
+	if mt.isLegacyCipherTxt(data) {
+		data, err = mt.cipher.(LegacyCipher).DecryptLegacy(ctx, fieldInfo(ctx, mtTag, primaryKey), data)
+		if err != nil {
+			err = mt.newError(mtTag.DBName + "字段（主键：" + primaryKey + "）的旧版本密文解密异常：" + err.Error())
+			return
+		}
+		legacy = true
+	} else {
+		var isCipherTxt bool
+		isCipherTxt, err = mt.isCipherTxt(ctx, mtTag, primaryKey, data)
+		if err != nil || isCipherTxt {
+			return
+		}
+	}
+	cipherValue, err = mt.encryptValue(ctx, mtTag, primaryKey, data)
+	return
+}
+
+// isCipherTxt
+/**
+ *  @Description: 按密文格式判断是否为密文，密文格式的值须可解密，解密失败（如 KMS 异常、密文被篡改）时返回错误并中止加密，避免重复加密
+ *  @receiver mt
+ *  @param ctx
+ *  @param mtTag
+ *  @param primaryKey
+ *  @param value
+ *  @return isCipherTxt
+ *  @return err
+ */
+func (mt *MultiTenancy) isCipherTxt(ctx context.Context, mtTag MultiTenancyTag, primaryKey string, value string) (isCipherTxt bool, err error) {
+	if mt.cipher == nil {
+		err = mt.newError("未设置加密方法")
+		return
+	}
+	isCipherTxt, err = mt.isCipherTxtFormat(value)
+	if err != nil || !isCipherTxt {
+		return
+	}
+	_, err = mt.cipher.Decrypt(ctx, fieldInfo(ctx, mtTag, primaryKey), value)
+	if err != nil && !errors.Is(err, ErrTampered) {
+		err = mt.newError(mtTag.DBName + "字段（主键：" + primaryKey + "）的密文解密异常：" + err.Error())
+	}
+	return
+}
@@ -0,0 +1,48 @@
+/**
+ * @Time    :2023/7/20 15:30
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package plugin
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTolerantRead(t *testing.T) {
+	mt, _ := newTestDB(t, nil)
+	mt.SetTolerantRead(true)
+	ctx := context.Background()
+	mtTag := MultiTenancyTag{Table: "users", DBName: "phone", Encrypt: true, Storage: storageText}
+	typ := reflect.TypeOf("")
+	if _, err := mt.decryptValue(ctx, mtTag, "", "138", typ); err == nil {
+		t.Fatal("tolerant read without cipher text format check should fail")
+	}
+	mt.SetCipherTxtFormat(func(value string) bool {
+		return strings.HasPrefix(value, "E")
+	})
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{name: "plaintext", value: "138", want: "138"},
+		{name: "cipher text", value: "E:138", want: "138"},
+		{name: "undecryptable cipher text", value: "Enc", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mt.decryptValue(ctx, mtTag, "", tt.value, typ)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got.String() != tt.want {
+				t.Errorf("got %q, want %q", got.String(), tt.want)
+			}
+		})
+	}
+}
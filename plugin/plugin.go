@@ -6,7 +6,9 @@
 package plugin
 
 import (
+	"github.com/melf-xyzh/multi-tenancy/id"
 	"gorm.io/gorm"
+	"sync"
 )
 
 const (
@@ -25,16 +27,21 @@ var MTPlugin *MultiTenancy
 type MultiTenancy struct {
 	tConn TenantDBConn
 	*gorm.DB
-	tenantTag           string
-	dbMap               map[string]*gorm.DB
-	tableMap            map[string]map[string]struct{}
-	dataIsolation       map[string]Model
-	tagMap              map[string]MultiTenancyTag
-	needEncryptDBFields map[string]struct{}
-	needEncryptFields   map[string]struct{}
-	encryptedSave       bool
-	encrypt             func(data string) (cipherTxt string, err error) // 加密函数
-	decrypt             func(cipherTxt string) (data string, err error) // 解密函数
+	tenantTag     string
+	dbMap         map[string]*gorm.DB
+	tableMap      map[string]map[string]struct{}
+	dataIsolation map[string]Model
+	modelTagMap   sync.Map // 各模型的 mt Tag 元数据，*schema.Schema -> *modelTags
+	encryptedSave bool
+	cipher        FieldCipher // 字段加解密实现
+	tolerantRead  bool        // 非密文格式的值按明文读取
+	// 密文格式校验，加解密实现未实现 CipherTxtChecker 时使用
+	cipherTxtFormat func(value string) bool
+	indexKey        []byte            // 查询索引密钥
+	timeLayout      string            // 字符串时间字段的格式
+	hashers         map[string]Hasher // 注册的哈希算法
+	hashMu          sync.RWMutex
+	idGenerator     func(tenantId string) (*id.DistributedIdGenerator, error) // 按租户获取ID生成器
 }
 
 func (mt *MultiTenancy) Name() string {
@@ -52,7 +59,7 @@ func (mt *MultiTenancy) Initialize(db *gorm.DB) error {
  *  @Description: 注册数据隔离插件
  *  @receiver mt
  *  @param dbMap 数据库Map
- *  @param tenantTag 数据隔离字段标识
+ *  @param tenantTag 数据隔离字段标识，模型未声明 mt:"tenant" 的字段时使用
  *  @return *MultiTenancy
  */
 func (mt *MultiTenancy) Register(tenantTag string, conn TenantDBConn) *MultiTenancy {
@@ -127,17 +134,29 @@ func (mt *MultiTenancy) getTenantTag() string {
  *  @param decrypt
  */
 func (mt *MultiTenancy) SetEncryptedSave(encrypt func(data string) (cipherTxt string, err error), decrypt func(cipherTxt string) (data string, err error)) {
-	mt.encryptedSave = true
-	mt.encrypt = encrypt
-	mt.decrypt = decrypt
-	if mt.tagMap == nil {
-		mt.tagMap = make(map[string]MultiTenancyTag)
-	}
-	if mt.needEncryptDBFields == nil {
-		mt.needEncryptDBFields = make(map[string]struct{})
-	}
-	if mt.needEncryptFields == nil {
-		mt.needEncryptFields = make(map[string]struct{})
-	}
+	mt.SetFieldCipher(funcCipher{encrypt: encrypt, decrypt: decrypt})
+	return
+}
+
+// SetEnvelopeEncryption
+/**
+ *  @Description: 注册信封加密存储，各租户使用独立的数据密钥
+ *  @receiver mt
+ *  @param envelope
+ */
+func (mt *MultiTenancy) SetEnvelopeEncryption(envelope *Envelope) {
+	mt.SetFieldCipher(envelope)
+	return
+}
+
+// SetTolerantRead
+/**
+ *  @Description: 设置兼容读取，开启后非密文格式的值按明文返回，用于存量明文数据加密的过渡期；
+ *  密文格式的值解密失败（如 KMS 异常、密文被篡改）时仍返回错误。须使用实现 CipherTxtChecker 的加解密实现或通过 SetCipherTxtFormat 注册密文格式校验
+ *  @receiver mt
+ *  @param tolerant
+ */
+func (mt *MultiTenancy) SetTolerantRead(tolerant bool) {
+	mt.tolerantRead = tolerant
 	return
 }
@@ -0,0 +1,155 @@
+/**
+ * @Time    :2023/7/3 15:02
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm/schema"
+	"reflect"
+)
+
+// EncryptSerializerName 加密序列化器名称，用于非string类型字段加密：gorm:"serializer:mt_encrypt"
+const EncryptSerializerName = "mt_encrypt"
+
+var errPluginNotRegistered = errors.New("【gorm:multi-tenancy】未注册多租户插件")
+
+func init() {
+	schema.RegisterSerializer(EncryptSerializerName, EncryptSerializer{})
+}
+
+// EncryptSerializer 字段加密序列化器
+type EncryptSerializer struct{}
+
+// Scan
+/**
+ *  @Description: 读取数据库中的密文，解密后还原为字段原始类型
+ *  @receiver EncryptSerializer
+ *  @param ctx
+ *  @param field
+ *  @param dst
+ *  @param dbValue
+ *  @return err
+ */
+func (EncryptSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) (err error) {
+	if MTPlugin == nil {
+		return errPluginNotRegistered
+	}
+	fieldValue, err := MTPlugin.decryptValue(ctx, MTPlugin.fieldTag(field), fieldPrimaryKey(ctx, field, dst), dbValue, field.FieldType)
+	if err != nil {
+		return
+	}
+	field.ReflectValueOf(ctx, dst).Set(fieldValue)
+	return
+}
+
+// Value
+/**
+ *  @Description: 将字段值序列化并加密后写入数据库
+ *  @receiver EncryptSerializer
+ *  @param ctx
+ *  @param field
+ *  @param dst
+ *  @param fieldValue
+ *  @return interface{}
+ *  @return error
+ */
+func (EncryptSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	if MTPlugin == nil {
+		return nil, errPluginNotRegistered
+	}
+	rv := reflect.ValueOf(fieldValue)
+	if !rv.IsValid() || (rv.Kind() == reflect.Ptr && rv.IsNil()) {
+		return nil, nil
+	}
+	return MTPlugin.encryptValue(ctx, MTPlugin.fieldTag(field), fieldPrimaryKey(ctx, field, dst), fieldValue)
+}
+
+// EncryptedString 加密字符串，写入数据库时加密、读取时解密，无需 mt Tag 及 serializer 声明
+type EncryptedString string
+
+// Scan
+/**
+ *  @Description: 读取数据库中的密文并解密
+ *  @receiver es
+ *  @param ctx
+ *  @param field
+ *  @param dst
+ *  @param dbValue
+ *  @return err
+ */
+func (es *EncryptedString) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) (err error) {
+	if MTPlugin == nil {
+		return errPluginNotRegistered
+	}
+	fieldValue, err := MTPlugin.decryptValue(ctx, MTPlugin.fieldTag(field), fieldPrimaryKey(ctx, field, dst), dbValue, reflect.TypeOf(*es))
+	if err != nil {
+		return
+	}
+	*es = fieldValue.Interface().(EncryptedString)
+	return
+}
+
+// Value
+/**
+ *  @Description: 加密后写入数据库
+ *  @receiver es
+ *  @param ctx
+ *  @param field
+ *  @param dst
+ *  @param fieldValue
+ *  @return interface{}
+ *  @return error
+ */
+func (es EncryptedString) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	if MTPlugin == nil {
+		return nil, errPluginNotRegistered
+	}
+	return MTPlugin.encryptValue(ctx, MTPlugin.fieldTag(field), fieldPrimaryKey(ctx, field, dst), string(es))
+}
+
+// String
+/**
+ *  @Description: 获取明文
+ *  @receiver es
+ *  @return string
+ */
+func (es EncryptedString) String() string {
+	return string(es)
+}
+
+// fieldPrimaryKey
+/**
+ *  @Description: 获取字段所在行的主键，用于绑定主键的字段：结构体的主键，未赋值时为更新语句操作的单行主键
+ *  @param ctx
+ *  @param field
+ *  @param dst
+ *  @return primaryKey
+ */
+func fieldPrimaryKey(ctx context.Context, field *schema.Field, dst reflect.Value) (primaryKey string) {
+	if !MTPlugin.fieldTag(field).Bind {
+		return
+	}
+	primaryKey = primaryKeyOf(ctx, field.Schema, dst)
+	if primaryKey == "" {
+		primaryKey = primaryKeyFromContext(ctx)
+	}
+	return
+}
+
+// isEncryptSerializer
+/**
+ *  @Description: 字段是否使用加密序列化器
+ *  @param field
+ *  @return bool
+ */
+func isEncryptSerializer(field *schema.Field) bool {
+	switch field.Serializer.(type) {
+	case EncryptSerializer, *EncryptSerializer, *EncryptedString:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,459 @@
+/**
+ * @Time    :2023/7/10 09:40
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"github.com/melf-xyzh/multi-tenancy/id"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"strings"
+)
+
+const DefaultTagName = "mt"
+
+const (
+	ModeDeterministic = "deterministic" // 确定性加密：相同明文的密文相同，支持精确匹配查询
+	ModeRandom        = "random"        // 随机加密：相同明文的密文不同，不支持作为查询条件
+)
+
+// LayoutTenant DistributedId 主键的ID结构为 id.TenantLayout，由ID中的租户编号选择数据库；
+// 其他包含租户编号的ID结构须通过 id.RegisterLayout 注册后以注册名称声明
+const LayoutTenant = id.LayoutNameTenant
+
+type MultiTenancyTag struct {
+	Table     string // 模型的表名
+	DBName    string
+	FieldName string
+	FieldType reflect.Type
+	tag       string
+	Encrypt   bool
+	Storage   string // 密文存储方式：text（默认）、binary
+	Mask      string // 脱敏方式：phone、idcard、email、name、bankcard
+	Mode      string // 加密方式：deterministic、random，未指定时由加解密实现决定
+	Bind      bool   // 密文绑定所在行的主键，读写时须已知主键
+	Hash      string // 哈希算法：sm3（默认）、hmac-sha256、bcrypt、argon2 等，为空时不哈希
+	Tenant    bool   // 是否为数据隔离字段
+	Layout    string // 主键的ID结构：tenant 或通过 id.RegisterLayout 注册的名称，为空时不由ID获取租户
+	Index     string // 查询索引字段（字段名或数据库字段名），保存明文的带密钥哈希，精确匹配查询改写为查询该字段
+	Ignore    bool   // 忽略该字段，不继承嵌入结构体上声明的 mt Tag
+}
+
+// modelTags 模型的 mt Tag 元数据
+type modelTags struct {
+	tagMap        map[string]MultiTenancyTag // DBName -> Tag
+	encryptFields map[string]struct{}        // 需要加密的字段 DBName
+	hashFields    map[string]struct{}        // 需要哈希的字段 DBName
+	indexColumns  map[string]string          // 加密字段 DBName -> 查询索引字段 DBName
+	fields        []MultiTenancyTag          // 按字段顺序排列的 mt Tag
+	tenantField   string                     // 声明 mt:"tenant" 的数据隔离字段 DBName
+	bind          bool                       // 是否包含绑定主键的加密字段
+	tenantLayout  bool                       // 主键是否声明 mt:"layout:名称"
+	layout        id.Layout                  // 主键声明的ID结构
+	err           error                      // mt Tag 解析异常
+}
+
+// needEncrypt
+/**
+ *  @Description: 字段是否需要加密
+ *  @receiver tags
+ *  @param dbName
+ *  @return bool
+ */
+func (tags *modelTags) needEncrypt(dbName string) bool {
+	_, ok := tags.encryptFields[dbName]
+	return ok
+}
+
+// indexColumn
+/**
+ *  @Description: 获取加密字段的查询索引字段
+ *  @receiver tags
+ *  @param dbName
+ *  @return string 未声明 index 时为空
+ */
+func (tags *modelTags) indexColumn(dbName string) string {
+	return tags.indexColumns[dbName]
+}
+
+// needHash
+/**
+ *  @Description: 字段是否需要哈希
+ *  @receiver tags
+ *  @param dbName
+ *  @return bool
+ */
+func (tags *modelTags) needHash(dbName string) bool {
+	_, ok := tags.hashFields[dbName]
+	return ok
+}
+
+const (
+	tagIgnore  = "-"       // 忽略该字段
+	tagEncrypt = "encrypt" // 加密保存
+	tagTenant  = "tenant"  // 数据隔离字段
+	tagHash    = "hash"    // 单向哈希，可指定算法 hash:bcrypt
+	tagMask    = "mask"    // 脱敏方式
+	tagStorage = "storage" // 密文存储方式
+	tagMode    = "mode"    // 加密方式
+	tagBind    = "bind"    // 密文绑定所在行的主键
+	tagLayout  = "layout"  // 主键的ID结构
+	tagIndex   = "index"   // 查询索引字段
+)
+
+// tagOptions 各选项是否须指定值：true 须指定，false 不可指定，不在其中的选项为未知选项
+var tagOptions = map[string]bool{
+	tagIgnore:  false,
+	tagEncrypt: false,
+	tagTenant:  false,
+	tagMask:    true,
+	tagStorage: true,
+	tagMode:    true,
+	tagBind:    false,
+	tagLayout:  true,
+	tagIndex:   true,
+}
+
+// tagValues 选项的可选值
+var tagValues = map[string][]string{
+	tagMask:    {MaskPhone, MaskIdCard, MaskEmail, MaskName, MaskBankCard},
+	tagStorage: {storageText, storageBinary},
+	tagMode:    {ModeDeterministic, ModeRandom},
+}
+
+// ParseTag
+/**
+ *  @Description: 解析并校验 mt Tag，选项以 ; 分隔，格式为 key 或 key:value，如 mt:"encrypt;mode:deterministic;mask:phone"
+ *  @param tag
+ *  @return mtTag
+ *  @return err 未知选项、缺少或多余的值、非法的值、重复或冲突的选项
+ */
+func ParseTag(tag string) (mtTag MultiTenancyTag, err error) {
+	err = parseTag(tag, &mtTag)
+	if err != nil {
+		mtTag = MultiTenancyTag{}
+	}
+	return
+}
+
+// parseTag
+/**
+ *  @Description: 解析 mt Tag 到 mtTag
+ *  @param tag
+ *  @param mtTag
+ *  @return err
+ */
+func parseTag(tag string, mtTag *MultiTenancyTag) (err error) {
+	seen := make(map[string]struct{})
+	for _, option := range strings.Split(tag, ";") {
+		option = strings.TrimSpace(option)
+		if option == "" {
+			continue
+		}
+		key, value := option, ""
+		hasValue := false
+		if index := strings.Index(option, ":"); index >= 0 {
+			key, value, hasValue = strings.TrimSpace(option[:index]), strings.TrimSpace(option[index+1:]), true
+		}
+		if _, ok := seen[key]; ok {
+			return errors.New("选项 " + key + " 重复")
+		}
+		seen[key] = struct{}{}
+		if key == tagHash {
+			// 未指定算法时使用加盐 SM3
+			if hasValue && value == "" {
+				return errors.New("选项 hash 须指定算法，如 hash:" + HashSM3)
+			}
+			mtTag.Hash = HashSM3
+			if hasValue {
+				mtTag.Hash = value
+			}
+			continue
+		}
+		needValue, ok := tagOptions[key]
+		if !ok {
+			return errors.New("未知的选项 " + key)
+		}
+		if !needValue && hasValue {
+			return errors.New("选项 " + key + " 不可指定值")
+		}
+		if needValue && value == "" {
+			return errors.New("选项 " + key + " 须指定值，如 " + key + ":xxx")
+		}
+		if values, ok := tagValues[key]; ok && !containsString(values, value) {
+			return errors.New("选项 " + key + " 的值须为 " + strings.Join(values, "、") + "，实际为 " + value)
+		}
+		switch key {
+		case tagIgnore:
+			mtTag.Ignore = true
+		case tagEncrypt:
+			mtTag.Encrypt = true
+		case tagTenant:
+			mtTag.Tenant = true
+		case tagMask:
+			mtTag.Mask = value
+		case tagStorage:
+			mtTag.Storage = value
+		case tagMode:
+			mtTag.Mode = value
+		case tagBind:
+			mtTag.Bind = true
+		case tagLayout:
+			mtTag.Layout = value
+		case tagIndex:
+			mtTag.Index = value
+		}
+	}
+	switch {
+	case mtTag.Ignore && len(seen) > 1:
+		return errors.New("选项 - 不可与其他选项同时使用")
+	case mtTag.Layout != "" && len(seen) > 1:
+		return errors.New("选项 layout 不可与其他选项同时使用")
+	case mtTag.Hash != "" && mtTag.Encrypt:
+		return errors.New("选项 hash 与 encrypt 不可同时使用")
+	case mtTag.Hash != "" && (mtTag.Storage != "" || mtTag.Mode != ""):
+		return errors.New("哈希字段不支持 storage、mode")
+	case mtTag.Tenant && (mtTag.Encrypt || mtTag.Hash != ""):
+		return errors.New("数据隔离字段不可加密或哈希")
+	case mtTag.Bind && !mtTag.Encrypt:
+		return errors.New("选项 bind 须与 encrypt 同时使用")
+	case mtTag.Index != "" && !mtTag.Encrypt:
+		return errors.New("选项 index 须与 encrypt 同时使用")
+	case mtTag.Bind && mtTag.Mode == ModeDeterministic:
+		return errors.New("确定性加密的字段须作为查询条件，不可绑定主键")
+	}
+	return
+}
+
+// containsString
+/**
+ *  @Description: 切片中是否包含字符串
+ *  @param values
+ *  @param value
+ *  @return bool
+ */
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// analyzeSchema
+/**
+ *  @Description: 解析模型中各字段的 mt Tag，按 schema.Schema（模型类型及表名）缓存，仅解析一次
+ *  @receiver mt
+ *  @param sch
+ *  @return tags
+ */
+func (mt *MultiTenancy) analyzeSchema(sch *schema.Schema) (tags *modelTags) {
+	if v, ok := mt.modelTagMap.Load(sch); ok {
+		return v.(*modelTags)
+	}
+	tags = &modelTags{
+		tagMap:        make(map[string]MultiTenancyTag),
+		encryptFields: make(map[string]struct{}),
+		hashFields:    make(map[string]struct{}),
+		indexColumns:  make(map[string]string),
+	}
+	for _, field := range sch.Fields {
+		if field.DBName == "" {
+			continue
+		}
+		mtTag := MultiTenancyTag{
+			Table:     sch.Table,
+			DBName:    field.DBName,
+			FieldName: field.Name,
+			FieldType: field.FieldType,
+		}
+		value, ok := field.Tag.Lookup(DefaultTagName)
+		if ok {
+			mtTag.tag = value
+		} else if !field.PrimaryKey && (field.Serializer != nil || canHoldCipherTxt(field.FieldType)) {
+			// 继承嵌入结构体上声明的 mt Tag
+			mtTag.tag = embeddedMTTag(sch.ModelType, field.StructField.Index)
+		}
+		// 解析MTTag
+		if err := parseTag(mtTag.tag, &mtTag); err != nil {
+			if tags.err == nil {
+				tags.err = mt.newError(sch.Name + "." + field.Name + "字段的 mt Tag 异常：" + err.Error())
+			}
+			continue
+		}
+		if mtTag.Ignore {
+			continue
+		}
+		if isEncryptSerializer(field) {
+			mtTag.Encrypt = true
+		}
+		tags.tagMap[mtTag.DBName] = mtTag
+		tags.fields = append(tags.fields, mtTag)
+		if mtTag.Tenant {
+			if tags.tenantField != "" && tags.err == nil {
+				tags.err = mt.newError(sch.Name + "只能声明一个数据隔离字段（mt:\"tenant\"）")
+			}
+			tags.tenantField = mtTag.DBName
+		}
+		if mtTag.Hash != "" {
+			// 解析时校验哈希算法，避免写入时才发现未注册
+			if _, err := mt.hasher(mtTag.Hash); err != nil && tags.err == nil {
+				tags.err = mt.newError(sch.Name + "." + field.Name + "字段的 mt Tag 异常：未注册哈希算法 " + mtTag.Hash + "，请在使用模型前通过 SetHasher 注册")
+			}
+			// 哈希字段只写不读，无需加密
+			tags.hashFields[mtTag.DBName] = struct{}{}
+		} else if mtTag.Encrypt {
+			tags.encryptFields[mtTag.DBName] = struct{}{}
+		}
+		if mtTag.Layout != "" {
+			if field != sch.PrioritizedPrimaryField || !isDistributedId(field.FieldType) {
+				if tags.err == nil {
+					tags.err = mt.newError(sch.Name + "." + field.Name + "字段的 mt Tag 异常：选项 layout 仅可声明在 DistributedId 主键上")
+				}
+			} else if layout, ok := id.LookupLayout(mtTag.Layout); !ok {
+				if tags.err == nil {
+					tags.err = mt.newError(sch.Name + "." + field.Name + "字段的 mt Tag 异常：未注册ID结构 " + mtTag.Layout + "，请在使用模型前通过 id.RegisterLayout 注册")
+				}
+			} else {
+				tags.tenantLayout = true
+				tags.layout = layout
+			}
+		}
+		if mtTag.Bind {
+			if sch.PrioritizedPrimaryField == nil && tags.err == nil {
+				tags.err = mt.newError(sch.Name + "." + field.Name + "字段绑定了所在行的主键，模型须声明主键")
+			}
+			tags.bind = true
+		}
+	}
+	if err := mt.analyzeIndex(sch, tags); err != nil && tags.err == nil {
+		tags.err = err
+	}
+	v, _ := mt.modelTagMap.LoadOrStore(sch, tags)
+	return v.(*modelTags)
+}
+
+// analyzeIndex
+/**
+ *  @Description: 校验加密字段的查询索引字段：须为模型中未声明 mt Tag 的 string 字段，且不可被多个加密字段使用
+ *  @receiver mt
+ *  @param sch
+ *  @param tags
+ *  @return err
+ */
+func (mt *MultiTenancy) analyzeIndex(sch *schema.Schema, tags *modelTags) (err error) {
+	for _, mtTag := range tags.fields {
+		if mtTag.Index == "" || !tags.needEncrypt(mtTag.DBName) {
+			continue
+		}
+		prefix := sch.Name + "." + mtTag.FieldName + "字段的 mt Tag 异常："
+		field := sch.LookUpField(mtTag.Index)
+		if field == nil || field.DBName == "" {
+			return mt.newError(prefix + "查询索引字段 " + mtTag.Index + " 不存在")
+		}
+		if field.FieldType.Kind() != reflect.String {
+			return mt.newError(prefix + "查询索引字段 " + field.Name + " 须为 string")
+		}
+		if indexTag := tags.tagMap[field.DBName]; indexTag.Encrypt || indexTag.Hash != "" || indexTag.Tenant || indexTag.Index != "" || indexTag.Layout != "" {
+			return mt.newError(prefix + "查询索引字段 " + field.Name + " 不可声明 mt Tag")
+		}
+		for column, indexColumn := range tags.indexColumns {
+			if indexColumn == field.DBName {
+				return mt.newError(prefix + "查询索引字段 " + field.Name + " 已被 " + column + " 字段使用")
+			}
+		}
+		tags.indexColumns[mtTag.DBName] = field.DBName
+	}
+	return
+}
+
+// ModelTags
+/**
+ *  @Description: 解析并校验模型中各字段的 mt Tag
+ *  @receiver mt
+ *  @param model 模型，如 &User{}
+ *  @return tags 按字段顺序排列，不含声明 mt:"-" 的字段
+ *  @return err
+ */
+func (mt *MultiTenancy) ModelTags(model interface{}) (tags []MultiTenancyTag, err error) {
+	tx := mt.DB.Session(&gorm.Session{NewDB: true, Context: context.Background()})
+	err = tx.Statement.Parse(model)
+	if err != nil {
+		return
+	}
+	parsed := mt.analyzeSchema(tx.Statement.Schema)
+	if parsed.err != nil {
+		err = parsed.err
+		return
+	}
+	tags = append(tags, parsed.fields...)
+	return
+}
+
+// checkModelTags
+/**
+ *  @Description: 校验语句模型的 mt Tag，异常时记录到 db.Error
+ *  @receiver mt
+ *  @param db
+ *  @return ok
+ */
+func (mt *MultiTenancy) checkModelTags(db *gorm.DB) (ok bool) {
+	if db.Statement.Schema != nil {
+		if err := mt.analyzeSchema(db.Statement.Schema).err; err != nil {
+			_ = db.AddError(err)
+			return
+		}
+	}
+	return true
+}
+
+// fieldTag
+/**
+ *  @Description: 获取字段的 mt Tag
+ *  @receiver mt
+ *  @param field
+ *  @return mtTag
+ */
+func (mt *MultiTenancy) fieldTag(field *schema.Field) (mtTag MultiTenancyTag) {
+	if field.Schema != nil {
+		if mtTag, ok := mt.analyzeSchema(field.Schema).tagMap[field.DBName]; ok {
+			return mtTag
+		}
+	}
+	if value, ok := field.Tag.Lookup(DefaultTagName); ok {
+		_ = parseTag(value, &mtTag)
+	}
+	return
+}
+
+// embeddedMTTag
+/**
+ *  @Description: 获取字段所在嵌入结构体上声明的 mt Tag（就近优先）
+ *  @param modelType
+ *  @param index 字段索引
+ *  @return tag
+ */
+func embeddedMTTag(modelType reflect.Type, index []int) (tag string) {
+	typ := modelType
+	for _, idx := range index[:len(index)-1] {
+		for typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		// 指针类型的嵌入结构体索引为负数
+		if idx < 0 {
+			idx = -idx - 1
+		}
+		structField := typ.Field(idx)
+		if value, ok := structField.Tag.Lookup(DefaultTagName); ok {
+			tag = value
+		}
+		typ = structField.Type
+	}
+	return
+}
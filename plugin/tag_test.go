@@ -0,0 +1,33 @@
+/**
+ * @Time    :2023/7/10 10:30
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+type customHashUser struct {
+	ID       int64
+	Password string `mt:"hash:custom"`
+}
+
+func TestHashAlgorithmValidation(t *testing.T) {
+	mt, db := newTestDB(t, nil)
+	if _, err := mt.ModelTags(&customHashUser{}); err == nil {
+		t.Fatal("unregistered hash algorithm: expected error")
+	}
+	mt.SetHasher("custom", sm3Hasher{})
+	if _, err := mt.ModelTags(&customHashUser{}); err != nil {
+		t.Fatalf("registered hash algorithm: %v", err)
+	}
+	tx := db.Session(&gorm.Session{NewDB: true, Context: context.Background()}).Create(&customHashUser{ID: 1, Password: "secret"})
+	if tx.Error != nil {
+		t.Fatal(tx.Error)
+	}
+}
@@ -0,0 +1,173 @@
+/**
+ * @Time    :2023/7/25 16:20
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package plugin
+
+import (
+	"github.com/melf-xyzh/multi-tenancy/id"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"reflect"
+)
+
+const (
+	createTimeFieldName = "CreateTime"
+	updateTimeFieldName = "UpdateTime"
+)
+
+// stringTimeField
+/**
+ *  @Description: 获取以字符串保存的时间字段（如 id.Model 的 CreateTime、UpdateTime）
+ *  @param sch
+ *  @param name 字段名
+ *  @return field 不存在或不是字符串类型时为 nil
+ */
+func stringTimeField(sch *schema.Schema, name string) (field *schema.Field) {
+	field = sch.LookUpField(name)
+	if field == nil || field.DBName == "" || field.FieldType.Kind() != reflect.String {
+		return nil
+	}
+	return
+}
+
+// SetTimeLayout
+/**
+ *  @Description: 设置字符串时间字段（CreateTime、UpdateTime、DeleteTime）的格式，会话通过 db.Set(id.TimeLayoutKey, layout) 指定时以会话为准
+ *  @receiver mt
+ *  @param layout 为空时使用 id.DefaultTimeLayout
+ */
+func (mt *MultiTenancy) SetTimeLayout(layout string) {
+	mt.timeLayout = layout
+	return
+}
+
+// storeTimeLayout
+/**
+ *  @Description: 会话未指定时间格式时，将插件的时间格式写入语句设置
+ *  @receiver mt
+ *  @param db
+ */
+func (mt *MultiTenancy) storeTimeLayout(db *gorm.DB) {
+	if mt.timeLayout != "" {
+		db.Statement.Settings.LoadOrStore(id.TimeLayoutKey, mt.timeLayout)
+	}
+	return
+}
+
+// now
+/**
+ *  @Description: 按时间格式格式化当前时间，时间取自 gorm.Config 的 NowFunc
+ *  @receiver mt
+ *  @param db
+ *  @return string
+ */
+func (mt *MultiTenancy) now(db *gorm.DB) string {
+	mt.storeTimeLayout(db)
+	return db.Statement.DB.NowFunc().Format(id.TimeLayout(db.Statement))
+}
+
+// timeCreateBeforeCallback
+/**
+ *  @Description: 创建前为未赋值的字符串 CreateTime、UpdateTime 赋值
+ *  @receiver mt
+ *  @param db
+ */
+func (mt *MultiTenancy) timeCreateBeforeCallback(db *gorm.DB) {
+	if db.Error != nil || db.Statement.Schema == nil {
+		return
+	}
+	var fields []*schema.Field
+	for _, name := range []string{createTimeFieldName, updateTimeFieldName} {
+		if field := stringTimeField(db.Statement.Schema, name); field != nil {
+			fields = append(fields, field)
+		}
+	}
+	if len(fields) == 0 {
+		return
+	}
+	db.Error = mt.setTime(db, fields, db.Statement.ReflectValue, mt.now(db))
+}
+
+// timeUpdateBeforeCallback
+/**
+ *  @Description: 更新前将字符串 UpdateTime 设置为当前时间，UpdateColumn、UpdateColumns 不更新
+ *  @receiver mt
+ *  @param db
+ */
+func (mt *MultiTenancy) timeUpdateBeforeCallback(db *gorm.DB) {
+	if db.Error != nil || db.Statement.Schema == nil || db.Statement.SkipHooks {
+		return
+	}
+	field := stringTimeField(db.Statement.Schema, updateTimeFieldName)
+	if field == nil {
+		return
+	}
+	if updateInfo, ok := db.Statement.Dest.(map[string]interface{}); ok {
+		if _, ok := updateInfo[field.DBName]; ok {
+			return
+		}
+		if _, ok := updateInfo[field.Name]; ok {
+			return
+		}
+		updateInfo[field.DBName] = mt.now(db)
+		// 语句执行后移除，避免修改调用方的 Map
+		mt.addRestore(db, func() { delete(updateInfo, field.DBName) })
+		return
+	}
+	updatingValue, updatingSchema, _ := mt.updatingStruct(db)
+	if db.Error != nil || updatingSchema == nil {
+		return
+	}
+	if updatingField := stringTimeField(updatingSchema, updateTimeFieldName); updatingField != nil {
+		db.Error = updatingField.Set(db.Statement.Context, updatingValue, mt.now(db))
+	}
+}
+
+// setTime
+/**
+ *  @Description: 为结构体（或结构体切片）中未赋值的时间字段赋值
+ *  @receiver mt
+ *  @param db
+ *  @param fields
+ *  @param rv
+ *  @param value
+ *  @return err
+ */
+func (mt *MultiTenancy) setTime(db *gorm.DB, fields []*schema.Field, rv reflect.Value, value string) (err error) {
+	rv = reflect.Indirect(rv)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			err = mt.setTime(db, fields, rv.Index(i), value)
+			if err != nil {
+				return
+			}
+		}
+	case reflect.Struct:
+		for _, field := range fields {
+			if _, isZero := field.ValueOf(db.Statement.Context, rv); !isZero {
+				continue
+			}
+			err = field.Set(db.Statement.Context, rv, value)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// timeDeleteBeforeCallback
+/**
+ *  @Description: 删除前写入时间格式，软删除（id.DeleteTime）按该格式记录删除时间
+ *  @receiver mt
+ *  @param db
+ */
+func (mt *MultiTenancy) timeDeleteBeforeCallback(db *gorm.DB) {
+	if db.Error != nil {
+		return
+	}
+	mt.storeTimeLayout(db)
+}
@@ -0,0 +1,69 @@
+/**
+ * @Time    :2023/7/25 17:10
+ * @Author  :Xiaoyu.Zhang
+ */
+
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/melf-xyzh/multi-tenancy/id"
+	"gorm.io/gorm"
+)
+
+type timeUser struct {
+	ID         int64
+	Name       string
+	CreateTime string
+	UpdateTime string
+	DeleteTime id.DeleteTime
+}
+
+func TestTimeLayout(t *testing.T) {
+	mt, db := newTestDB(t, nil)
+	current := time.Date(2023, 7, 25, 17, 10, 0, 0, time.Local)
+	db.NowFunc = func() time.Time { return current }
+	tests := []struct {
+		name   string
+		layout string
+		exec   func(tx *gorm.DB) *gorm.DB
+		want   string
+	}{
+		{name: "default create", want: "2023-07-25 17:10:00", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Create(&timeUser{ID: 1})
+		}},
+		{name: "create", layout: time.RFC3339, want: current.Format(time.RFC3339), exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Create(&timeUser{ID: 1})
+		}},
+		{name: "update", layout: "20060102150405", want: "20230725171000", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&timeUser{ID: 1}).Update("name", "a")
+		}},
+		{name: "soft delete", layout: "20060102150405", want: "20230725171000", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Delete(&timeUser{ID: 1})
+		}},
+		{name: "session", layout: "20060102150405", want: "2023/07/25", exec: func(tx *gorm.DB) *gorm.DB {
+			return tx.Set(id.TimeLayoutKey, "2006/01/02").Delete(&timeUser{ID: 1})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mt.SetTimeLayout(tt.layout)
+			tx := tt.exec(db.Session(&gorm.Session{NewDB: true, Context: context.Background()}))
+			if tx.Error != nil {
+				t.Fatal(tx.Error)
+			}
+			var found bool
+			for _, v := range tx.Statement.Vars {
+				if v == tt.want {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("vars = %#v, want %s", tx.Statement.Vars, tt.want)
+			}
+		})
+	}
+}